@@ -0,0 +1,101 @@
+package velox
+
+import (
+	"time"
+)
+
+// connAcks tracks the versions confirmed by a client
+// which connected with acknowledgements enabled (?ack=1)
+type connAcks struct {
+	version  int64       // last acknowledged version
+	pending  int64       // last sent version
+	sentAt   time.Time   // when the oldest unacknowledged version was sent
+	timer    *time.Timer // fires after AckTimeout without progress
+	resynced bool        // resynced since the last acknowledgement
+}
+
+// AckedVersion is the last version the client confirmed applying.
+// It is always zero unless the client enabled acknowledgements.
+func (c *conn) AckedVersion() int64 {
+	c.ackMut.Lock()
+	defer c.ackMut.Unlock()
+	return c.ack.version
+}
+
+// Lag is how long the oldest unacknowledged version has been
+// waiting for the client, or zero when it is up to date.
+func (c *conn) Lag() time.Duration {
+	c.ackMut.Lock()
+	defer c.ackMut.Unlock()
+	if c.ack.pending <= c.ack.version {
+		return 0
+	}
+	return time.Since(c.ack.sentAt)
+}
+
+// awaitAck marks the given version as sent, starting the
+// timeout unless an earlier version is still unacknowledged
+func (c *conn) awaitAck(version int64) {
+	c.ackMut.Lock()
+	defer c.ackMut.Unlock()
+	c.ack.pending = version
+	if c.ack.timer == nil && version > c.ack.version {
+		c.ack.sentAt = time.Now()
+		c.ack.timer = time.AfterFunc(c.state.AckTimeout, c.ackTimeout)
+	}
+}
+
+// acked records a version confirmed by the client
+func (c *conn) acked(version int64) {
+	c.ackMut.Lock()
+	defer c.ackMut.Unlock()
+	if version <= c.ack.version {
+		return
+	}
+	c.ack.version = version
+	c.ack.resynced = false
+	if c.ack.timer == nil {
+		return
+	}
+	if version >= c.ack.pending {
+		c.ack.timer.Stop()
+		c.ack.timer = nil
+	} else {
+		//progressing, but behind
+		c.ack.sentAt = time.Now()
+		c.ack.timer.Reset(c.state.AckTimeout)
+	}
+}
+
+// ackTimeout resyncs a client which stopped acknowledging versions,
+// and closes it if it still does not acknowledge the full state
+func (c *conn) ackTimeout() {
+	c.ackMut.Lock()
+	c.ack.timer = nil
+	if c.ack.version >= c.ack.pending {
+		c.ackMut.Unlock()
+		return
+	}
+	resynced := c.ack.resynced
+	c.ack.resynced = true
+	acked, pending := c.ack.version, c.ack.pending
+	c.ackMut.Unlock()
+	if resynced {
+		c.log.Warn("velox: ack timeout after resync, closing", "acked", acked, "version", pending)
+		c.Close()
+		return
+	}
+	c.log.Warn("velox: ack timeout, resyncing", "acked", acked, "version", pending)
+	c.resync()
+	c.Push()
+}
+
+// stopAcks cancels any pending ack timeout
+func (c *conn) stopAcks() {
+	c.ackMut.Lock()
+	if c.ack.timer != nil {
+		c.ack.timer.Stop()
+		c.ack.timer = nil
+	}
+	c.ackMut.Unlock()
+}
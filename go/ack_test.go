@@ -0,0 +1,149 @@
+package velox_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestClientAck(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Throttle = 10 * time.Millisecond
+	conns := make(chan velox.Conn, 1)
+	test.State.Hooks = &velox.Hooks{OnConnect: func(c velox.Conn, transport velox.Transport) { conns <- c }}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	clientData := &struct {
+		sync.Mutex
+		Value string `json:"value"`
+	}{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportWebSocket
+	client.Ack = true
+	client.Retry = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	conn := <-conns
+	waitFor(t, "initial ack", func() bool { return conn.AckedVersion() == 1 })
+	test.Lock()
+	test.Value = "b"
+	test.Unlock()
+	test.Push()
+	waitFor(t, "second ack", func() bool { return conn.AckedVersion() == 2 })
+	if lag := conn.Lag(); lag != 0 {
+		t.Fatalf("Expected no lag, got %s", lag)
+	}
+}
+
+func TestClientAckSSE(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Throttle = 10 * time.Millisecond
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	clientData := &struct {
+		sync.Mutex
+		Value string `json:"value"`
+	}{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportSSE
+	client.Ack = true
+	var connects atomic.Int32
+	client.OnConnect = func() { connects.Add(1) }
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "initial state", func() bool { return client.Version() == 1 })
+	for _, value := range []string{"b", "c"} {
+		test.Lock()
+		test.Value = value
+		test.Unlock()
+		test.Push()
+		waitFor(t, value, func() bool {
+			clientData.Lock()
+			defer clientData.Unlock()
+			return clientData.Value == value
+		})
+	}
+	// updates are applied on the same connection
+	if n := connects.Load(); n != 1 {
+		t.Fatalf("Expected 1 connect, got %d", n)
+	}
+	if v := client.Version(); v != 3 {
+		t.Fatalf("Expected version 3, got %d", v)
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.AckTimeout = 100 * time.Millisecond
+	conns := make(chan velox.Conn, 1)
+	test.State.Hooks = &velox.Hooks{OnConnect: func(c velox.Conn, transport velox.Transport) { conns <- c }}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	// a client which opts in, but never acknowledges
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?ack=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer ws.Close()
+	conn := <-conns
+	updates := make(chan *velox.Update, 10)
+	go func() {
+		defer close(updates)
+		for {
+			u := &velox.Update{}
+			if err := ws.ReadJSON(u); err != nil {
+				return
+			}
+			if !u.Ping {
+				updates <- u
+			}
+		}
+	}()
+	if u := <-updates; u.Version != 1 || u.ID == "" {
+		t.Fatalf("Expected initial state, got %+v", u)
+	}
+	waitFor(t, "lag", func() bool { return conn.Lag() > 0 })
+	// the full state is resent after the timeout...
+	if u := <-updates; u.Version != 1 || u.ID == "" || u.Delta {
+		t.Fatalf("Expected resync, got %+v", u)
+	}
+	// ...then the connection is closed
+	if u, ok := <-updates; ok {
+		t.Fatalf("Expected close, got %+v", u)
+	}
+	if conn.AckedVersion() != 0 {
+		t.Fatalf("Expected no acks, got %d", conn.AckedVersion())
+	}
+}
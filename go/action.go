@@ -0,0 +1,105 @@
+package velox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ActionFunc handles a named action sent by a client over its
+// WebSocket connection. The returned value is marshalled and
+// sent back to the caller, as is the text of a returned error.
+type ActionFunc func(c Conn, name string, payload json.RawMessage) (any, error)
+
+// ActionError is returned by Client.Call when the server
+// responds to an action with an error.
+type ActionError struct {
+	Action   string
+	Message  string
+	Conflict bool // the server returned ErrConflict
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("velox: action %s: %s", e.Action, e.Message)
+}
+
+// Is allows errors.Is to match ErrConflict
+func (e *ActionError) Is(target error) bool {
+	return target == ErrConflict && e.Conflict
+}
+
+// HandleAction registers the handler for the named action,
+// replacing any existing handler. A nil handler removes it.
+func (s *State) HandleAction(name string, fn ActionFunc) {
+	s.actionMut.Lock()
+	defer s.actionMut.Unlock()
+	if fn == nil {
+		delete(s.actions, name)
+		return
+	}
+	if s.actions == nil {
+		s.actions = map[string]ActionFunc{}
+	}
+	s.actions[name] = fn
+}
+
+func (s *State) action(name string) ActionFunc {
+	s.actionMut.Lock()
+	defer s.actionMut.Unlock()
+	return s.actions[name]
+}
+
+// receive a raw message from the client
+func (c *conn) receive(b []byte) {
+	if string(b) == "ping" {
+		return
+	}
+	msg := &Message{}
+	if err := c.state.codec().Unmarshal(b, msg); err != nil {
+		c.log.Debug("velox: invalid message", "bytes", len(b), "err", err)
+		return
+	}
+	if msg.Ack > 0 {
+		c.acked(msg.Ack)
+	}
+	if msg.Action != "" {
+		go c.handleAction(msg)
+	}
+}
+
+// handleAction runs the action handler and replies to the caller
+func (c *conn) handleAction(msg *Message) {
+	result, err := c.runAction(msg)
+	reply := &Update{Reply: msg.ID}
+	if err == nil && result != nil {
+		reply.Payload, err = c.state.codec().Marshal(result)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		reply.Conflict = errors.Is(err, ErrConflict)
+	}
+	if msg.ID == 0 {
+		return //caller does not want a reply
+	}
+	if err := c.send(reply); err != nil && c.dropSlow(err) {
+		c.log.Warn("velox: send failed", "reply", msg.ID, "err", err)
+		c.Close()
+	}
+}
+
+func (c *conn) runAction(msg *Message) (result any, err error) {
+	fn := c.state.action(msg.Action)
+	if msg.Action == ProposeAction {
+		fn = c.state.propose
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("unknown action")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			c.log.Error("velox: action panic", "action", msg.Action, "panic", r)
+			result, err = nil, fmt.Errorf("action failed")
+		}
+	}()
+	return fn(c, msg.Action, msg.Payload)
+}
@@ -0,0 +1,141 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestClientCallAction(t *testing.T) {
+	type CounterState struct {
+		velox.State
+		sync.Mutex
+		Count int `json:"count"`
+	}
+	serverData := &CounterState{}
+	serverData.State.Throttle = 10 * time.Millisecond
+	handler := velox.SyncHandler(serverData)
+	serverData.State.HandleAction("add", func(c velox.Conn, name string, payload json.RawMessage) (any, error) {
+		var n int
+		if err := json.Unmarshal(payload, &n); err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errors.New("negative")
+		}
+		serverData.Lock()
+		serverData.Count += n
+		count := serverData.Count
+		serverData.Unlock()
+		serverData.Push()
+		return count, nil
+	})
+	serverData.State.HandleAction("stale", func(c velox.Conn, name string, payload json.RawMessage) (any, error) {
+		return nil, fmt.Errorf("stale: %w", velox.ErrConflict)
+	})
+	serverData.State.HandleAction("conflict-text", func(c velox.Conn, name string, payload json.RawMessage) (any, error) {
+		return nil, errors.New(velox.ErrConflict.Error())
+	})
+
+	l := bufconn.Listen(64 * 1024)
+	defer l.Close()
+	server := &http.Server{Handler: handler}
+	go server.Serve(l)
+	defer server.Close()
+
+	clientData := &ClientData{}
+	client, err := velox.NewClient("http://bufconn/sync", clientData)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.HTTPClient = bufconnClient(l)
+	client.Transport = velox.TransportWebSocket
+	client.Retry = false
+	connected := make(chan struct{})
+	updated := make(chan struct{}, 10)
+	client.OnConnect = func() { close(connected) }
+	client.OnUpdate = func() { updated <- struct{}{} }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	select {
+	case <-connected:
+	case <-ctx.Done():
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	// result is returned to the caller
+	var count int
+	if err := client.Call(ctx, "add", 2, &count); err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("Expected result 2, got %d", count)
+	}
+	// and the change is synced
+	deadline := time.After(2 * time.Second)
+	for {
+		clientData.Lock()
+		c := clientData.Count
+		clientData.Unlock()
+		if c == 2 {
+			break
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			t.Fatalf("Timeout waiting for count 2, got %d", c)
+		}
+	}
+	// handler errors are returned as ActionErrors
+	var actionErr *velox.ActionError
+	if err := client.Call(ctx, "add", -1, nil); !errors.As(err, &actionErr) || actionErr.Message != "negative" {
+		t.Fatalf("Expected negative ActionError, got %v", err)
+	}
+	if err := client.Call(ctx, "missing", nil, nil); !errors.As(err, &actionErr) {
+		t.Fatalf("Expected unknown action error, got %v", err)
+	}
+	// conflicts are flagged by the server, not matched by their text
+	if err := client.Call(ctx, "stale", nil, nil); !errors.Is(err, velox.ErrConflict) {
+		t.Fatalf("Expected ErrConflict, got %v", err)
+	}
+	if err := client.Call(ctx, "conflict-text", nil, nil); err == nil || errors.Is(err, velox.ErrConflict) {
+		t.Fatalf("Expected an error which is not ErrConflict, got %v", err)
+	}
+}
+
+func TestClientCallRequiresWebSocket(t *testing.T) {
+	serverData := &ServerData{Name: "sse"}
+	l := bufconn.Listen(64 * 1024)
+	defer l.Close()
+	server := &http.Server{Handler: velox.SyncHandler(serverData)}
+	go server.Serve(l)
+	defer server.Close()
+
+	client, err := velox.NewClient("http://bufconn/sync", &ClientData{})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.HTTPClient = bufconnClient(l)
+	client.Retry = false
+	connected := make(chan struct{})
+	client.OnConnect = func() { close(connected) }
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	<-connected
+	if err := client.Call(ctx, "add", 1, nil); err == nil {
+		t.Fatal("Expected error calling an action over SSE")
+	}
+}
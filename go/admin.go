@@ -0,0 +1,214 @@
+package velox
+
+import (
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed admin.html
+var adminHTML string
+
+var adminTemplate = template.Must(template.New("admin").Parse(adminHTML))
+
+// Admin is an http.Handler which lists the registered States and their
+// connections, as JSON, or as HTML for browsers. POST requests with
+// ?action=push&state=<name> push the state, with
+// ?action=push&state=<name>&conn=<id> force a connection to be sent the
+// full state, and with ?action=close&state=<name>&conn=<id> close a
+// connection.
+// Admin does not authenticate requests, so it must not be exposed publicly.
+// The zero value is ready to use.
+type Admin struct {
+	mut    sync.Mutex
+	states map[string]*State
+}
+
+// Register a State, or a struct embedding one, under the given name
+func (a *Admin) Register(name string, gostruct any) error {
+	s, ok := gostruct.(stateEmbedded)
+	if !ok {
+		return fmt.Errorf("velox: %T is not a State", gostruct)
+	}
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if a.states == nil {
+		a.states = map[string]*State{}
+	}
+	a.states[name] = s.self()
+	return nil
+}
+
+// Unregister the State with the given name
+func (a *Admin) Unregister(name string) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	delete(a.states, name)
+}
+
+type adminState struct {
+	Name        string      `json:"name"`
+	ID          string      `json:"id"`
+	Version     int64       `json:"version"`
+	Bytes       int         `json:"bytes"`
+	LastPush    time.Time   `json:"lastPush,omitzero"`
+	Throttle    string      `json:"throttle"`
+	Connections []adminConn `json:"connections"`
+}
+
+type adminConn struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Transport   Transport `json:"transport"`
+	Gzip        bool      `json:"gzip"`
+	Version     int64     `json:"version"`
+	Behind      int64     `json:"behind"`
+	BytesSent   int64     `json:"bytesSent"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		states := a.list()
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := adminTemplate.Execute(w, states); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(states)
+	case http.MethodPost:
+		if err := a.action(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		//back to the list, for html forms
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// action runs the action requested in the query
+func (a *Admin) action(r *http.Request) error {
+	q := r.URL.Query()
+	name := q.Get("state")
+	a.mut.Lock()
+	s, ok := a.states[name]
+	a.mut.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown state %q", name)
+	}
+	switch action := q.Get("action"); {
+	case action == "push" && !q.Has("conn"):
+		s.Push()
+		return nil
+	case action == "push":
+		c, err := s.adminConn(q.Get("conn"))
+		if err != nil {
+			return err
+		}
+		c.log.Info("velox: admin pushed connection")
+		//send the id and full state, even when up to date
+		c.resync()
+		c.Push()
+		return nil
+	case action == "close":
+		c, err := s.adminConn(q.Get("conn"))
+		if err != nil {
+			return err
+		}
+		c.log.Info("velox: admin closed connection")
+		return c.Close()
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+// adminConn finds the connection with the given id
+func (s *State) adminConn(id string) (*conn, error) {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	s.connMut.Lock()
+	c, ok := s.conns[n]
+	s.connMut.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown connection %q", id)
+	}
+	return c, nil
+}
+
+// list the registered states, by name
+func (a *Admin) list() []adminState {
+	a.mut.Lock()
+	names := make([]string, 0, len(a.states))
+	for name := range a.states {
+		names = append(names, name)
+	}
+	states := make([]*State, len(names))
+	slices.Sort(names)
+	for i, name := range names {
+		states[i] = a.states[name]
+	}
+	a.mut.Unlock()
+	list := make([]adminState, len(names))
+	for i, s := range states {
+		list[i] = s.adminState(names[i])
+	}
+	return list
+}
+
+// adminState describes the state and its connections, by id
+func (s *State) adminState(name string) adminState {
+	s.data.mut.RLock()
+	info := adminState{
+		Name:     name,
+		ID:       s.data.id,
+		Version:  s.data.version,
+		Bytes:    len(s.data.bytes),
+		Throttle: s.Throttle.String(),
+	}
+	s.data.mut.RUnlock()
+	if last := s.push.last.Load(); last > 0 {
+		info.LastPush = time.Unix(0, last)
+	}
+	s.connMut.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connMut.Unlock()
+	slices.SortFunc(conns, func(a, b *conn) int { return cmp.Compare(a.id, b.id) })
+	info.Connections = make([]adminConn, len(conns))
+	for i, c := range conns {
+		version := c.sentVersion()
+		info.Connections[i] = adminConn{
+			ID:          c.ID(),
+			User:        c.metadata.User,
+			RemoteAddr:  c.addr,
+			Transport:   c.transportType,
+			Gzip:        c.gzip,
+			Version:     version,
+			Behind:      info.Version - version,
+			BytesSent:   c.bytesSent.Load(),
+			ConnectedAt: c.connectedAt,
+		}
+	}
+	return info
+}
@@ -0,0 +1,129 @@
+package velox_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestAdmin(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Throttle = 20 * time.Millisecond
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	admin := &velox.Admin{}
+	if err := admin.Register("test", test); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Register("bad", &struct{}{}); err == nil {
+		t.Fatal("Expected error registering a non-State")
+	}
+	adminServer := httptest.NewServer(admin)
+	defer adminServer.Close()
+	client := &testClient{id: 1, url: server.URL}
+	if err := client.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.disconnect()
+	client.next() // ping
+	client.next() // initial state
+	type listing []struct {
+		Name        string
+		ID          string
+		Version     int64
+		Bytes       int
+		Throttle    string
+		Connections []struct {
+			ID          string
+			RemoteAddr  string
+			Transport   velox.Transport
+			Gzip        bool
+			Version     int64
+			BytesSent   int64
+			ConnectedAt time.Time
+		}
+	}
+	list := func() listing {
+		resp, err := http.Get(adminServer.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var l listing
+		if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	l := list()
+	if len(l) != 1 || l[0].Name != "test" || l[0].ID != test.ID() || l[0].Version != 1 || l[0].Bytes == 0 || l[0].Throttle != "20ms" {
+		t.Fatalf("Unexpected state listing %+v", l)
+	}
+	if len(l[0].Connections) != 1 {
+		t.Fatalf("Expected 1 connection, got %+v", l[0].Connections)
+	}
+	c := l[0].Connections[0]
+	if c.Transport != velox.TransportSSE || c.Version != 1 || c.BytesSent == 0 || c.RemoteAddr == "" || c.ConnectedAt.IsZero() {
+		t.Fatalf("Unexpected connection listing %+v", c)
+	}
+	// html view
+	req, _ := http.NewRequest("GET", adminServer.URL, nil)
+	req.Header.Set("Accept", "text/html")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "<h2>test") || !strings.Contains(string(body), c.RemoteAddr) {
+		t.Fatalf("Unexpected html view:\n%s", body)
+	}
+	// force push
+	test.Lock()
+	test.Value = "b"
+	test.Unlock()
+	post := func(query string) int {
+		resp, err := http.Post(adminServer.URL+"?"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("action=push&state=test"); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if u, _, err := client.next(); err != nil || u.Version != 2 {
+		t.Fatalf("Expected version 2, got %+v: %v", u, err)
+	}
+	// force push an up to date connection
+	if code := post("action=push&state=test&conn=0"); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", code)
+	}
+	if code := post("action=push&state=test&conn=" + c.ID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	if u, _, err := client.next(); err != nil || u.Version != 2 || u.Delta || u.ID != test.ID() || !strings.Contains(string(u.Body), `"value":"b"`) {
+		t.Fatalf("Expected full state at version 2, got %+v: %v", u, err)
+	}
+	// close
+	if code := post("action=close&state=test&conn=0"); code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", code)
+	}
+	if code := post("action=close&state=test&conn=" + c.ID); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+	waitFor(t, "close", func() bool { return len(list()[0].Connections) == 0 })
+}
@@ -0,0 +1,48 @@
+package velox
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ErrUnauthorized is returned by Handle when Authorize refuses a request
+// without a StatusError of its own, and is responded to with 403 Forbidden.
+var ErrUnauthorized = errors.New("velox: unauthorized")
+
+// AuthorizeFunc decides whether a request may connect, returning the
+// metadata to store on its connection. Returning an error refuses the
+// connection, with the error's Code when it is a StatusError.
+type AuthorizeFunc func(r *http.Request) (Metadata, error)
+
+// Metadata describes the caller of a connection, see Conn.Metadata.
+type Metadata struct {
+	User  string
+	Roles []string
+	Tags  map[string]string
+}
+
+// HasRole reports whether role is one of the Roles
+func (m Metadata) HasRole(role string) bool {
+	return slices.Contains(m.Roles, role)
+}
+
+// authorize the request, refusals are returned as a StatusError
+func (s *State) authorize(r *http.Request) (Metadata, error) {
+	if s.Authorize == nil {
+		return Metadata{}, nil
+	}
+	md, err := s.Authorize(r)
+	if err == nil {
+		return md, nil
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return Metadata{}, err
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		err = fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return Metadata{}, &StatusError{Code: http.StatusForbidden, Err: err}
+}
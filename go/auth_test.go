@@ -0,0 +1,103 @@
+package velox_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+type ctxKey struct{}
+
+func TestAuthorize(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Authorize = func(r *http.Request) (velox.Metadata, error) {
+		switch user := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); user {
+		case "":
+			return velox.Metadata{}, &velox.StatusError{Code: http.StatusUnauthorized, Err: errors.New("no token")}
+		case "mallory":
+			return velox.Metadata{}, errors.New("banned")
+		default:
+			return velox.Metadata{User: user, Roles: []string{"admin"}, Tags: map[string]string{"tab": "1"}}, nil
+		}
+	}
+	conns := make(chan velox.Conn, 1)
+	test.State.Hooks = &velox.Hooks{OnConnect: func(c velox.Conn, transport velox.Transport) { conns <- c }}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	get := func(ctx context.Context, user string) *http.Response {
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+user)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for user, code := range map[string]int{"": http.StatusUnauthorized, "mallory": http.StatusForbidden} {
+		resp := get(t.Context(), user)
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			t.Fatalf("%q: expected %d, got %d", user, code, resp.StatusCode)
+		}
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	resp := get(ctx, "alice")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	c := <-conns
+	if md := c.Metadata(); md.User != "alice" || !md.HasRole("admin") || md.Tags["tab"] != "1" {
+		t.Fatalf("Unexpected metadata %+v", md)
+	}
+	if c.Transport() != velox.TransportSSE || c.RemoteAddr() == "" || time.Since(c.ConnectedAt()) > 5*time.Second {
+		t.Fatalf("Unexpected conn %s %s %s", c.Transport(), c.RemoteAddr(), c.ConnectedAt())
+	}
+	waitFor(t, "initial version", func() bool { return c.Version() == 1 })
+	if c.Context().Err() != nil {
+		t.Fatal("Expected open context")
+	}
+	cancel()
+	select {
+	case <-c.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected context to be cancelled on close")
+	}
+}
+
+func TestConnContextValues(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	conns := make(chan velox.Conn, 1)
+	test.State.Hooks = &velox.Hooks{OnConnect: func(c velox.Conn, transport velox.Transport) { conns <- c }}
+	handler := velox.SyncHandler(test)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, "value")))
+	}))
+	defer server.Close()
+	client := &testClient{id: 1, url: server.URL}
+	if err := client.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.disconnect()
+	c := <-conns
+	if v := c.Context().Value(ctxKey{}); v != "value" {
+		t.Fatalf("Expected request context value, got %v", v)
+	}
+}
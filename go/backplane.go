@@ -0,0 +1,230 @@
+package velox
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultBackplaneTopic is the default State.BackplaneTopic value.
+var DefaultBackplaneTopic = "velox"
+
+// Publication is a new version of a State, shared with other
+// instances of the same State through a Backplane.
+type Publication struct {
+	Topic   string          `json:"topic"`
+	Origin  string          `json:"origin"` // the instance which made the version
+	ID      string          `json:"id"`
+	Version int64           `json:"version"`
+	Bytes   json.RawMessage `json:"bytes,omitempty"`
+	Delta   json.RawMessage `json:"delta,omitempty"` // from Version-1, if any
+}
+
+// Backplane distributes new versions between instances of a State,
+// for example replicas behind a load balancer. Every instance pushes
+// each publication to its own connections with the same id and version.
+//
+// Instances with Data may all push. Versions are ordered by number, then
+// by origin, and every instance adopts the latest, so replicas which push
+// concurrently converge on one of their versions, and the other change is
+// lost. Adopted versions are written into the data with State.Unmarshal.
+// A version built on another instance's version is named by a new id, so
+// its connections resync, and an instance which starts with an older
+// version than its peers adopts theirs.
+type Backplane interface {
+	// Publish sends a publication to all subscribers of its topic.
+	Publish(pub *Publication) error
+	// Subscribe calls fn with each publication to the topic, starting
+	// with the latest one if any. Cancel stops the subscription.
+	Subscribe(topic string, fn func(pub *Publication)) (cancel func(), err error)
+}
+
+// subscribeBackplane is called on init
+func (s *State) subscribeBackplane() error {
+	if s.Backplane == nil {
+		return nil
+	}
+	if s.BackplaneTopic == "" {
+		s.BackplaneTopic = DefaultBackplaneTopic
+	}
+	s.backplane.origin = randomID()
+	if s.Data != nil {
+		s.data.mut.Lock()
+		s.data.origin = s.backplane.origin
+		s.data.mut.Unlock()
+	}
+	cancel, err := s.Backplane.Subscribe(s.BackplaneTopic, s.receivePublication)
+	if err != nil {
+		return err
+	}
+	s.backplane.cancel = cancel
+	//instances with data then share their initial version
+	if s.Data != nil {
+		s.publish()
+	}
+	return nil
+}
+
+// publish the current version to other instances
+func (s *State) publish() {
+	if s.Backplane == nil {
+		return
+	}
+	s.data.mut.RLock()
+	pub := &Publication{
+		Topic:   s.BackplaneTopic,
+		Origin:  s.data.origin,
+		ID:      s.data.id,
+		Version: s.data.version,
+		Bytes:   s.data.bytes,
+		Delta:   s.data.delta,
+	}
+	s.data.mut.RUnlock()
+	if err := s.Backplane.Publish(pub); err != nil {
+		s.logger().Error("velox: backplane publish failed", "version", pub.Version, "err", err)
+	}
+}
+
+// receivePublication from another instance, adopting it when it
+// is later than the current version: by version, then by origin.
+// Its id and version are then pushed to local connections.
+func (s *State) receivePublication(pub *Publication) {
+	if pub.Origin == s.backplane.origin {
+		return
+	}
+	s.push.mut.Lock()
+	defer s.push.mut.Unlock()
+	d := &s.data
+	d.mut.RLock()
+	stale := pub.Version < d.version || pub.Version == d.version && pub.Origin <= d.origin
+	d.mut.RUnlock()
+	if stale {
+		return
+	}
+	//local pushes build on the adopted state (pushes wait for the
+	//push lock, so the data does not change in the meantime)
+	if s.Unmarshal != nil && len(pub.Bytes) > 0 {
+		if err := s.Unmarshal(pub.Bytes); err != nil {
+			s.logger().Error("velox: backplane unmarshal failed", "id", pub.ID, "version", pub.Version, "err", err)
+		}
+	}
+	d.mut.Lock()
+	//ids are only extended by the instance which made them,
+	//so a delta from the same id is from the current version
+	idChanged := pub.ID != d.id
+	if !idChanged && pub.Version == d.version+1 && pub.Delta != nil {
+		d.delta = pub.Delta
+		d.prev = d.bytes
+		d.history.add(pub.Version, pub.Delta, d.prev, s.codec(), s.DeltaHistory, s.DeltaHistoryBytes)
+	} else {
+		d.delta = nil
+		d.prev = nil
+		d.history.reset()
+	}
+	d.id = pub.ID
+	d.origin = pub.Origin
+	d.version = pub.Version
+	d.bytes = pub.Bytes
+	d.changed = time.Now()
+	//local pushes diff against the adopted state
+	d.patcher = mergePatcher{codec: s.codec()}
+	d.patcher.patch(pub.Bytes)
+	d.mut.Unlock()
+	s.logger().Debug("velox: backplane received", "id", pub.ID, "version", pub.Version, "bytes", len(pub.Bytes))
+	s.save()
+	if idChanged {
+		//versions from the old id are meaningless
+		s.resyncAll()
+	}
+	s.fanout(pub.Version)
+}
+
+// resyncAll sends every connection the full state next
+func (s *State) resyncAll() {
+	s.connMut.Lock()
+	for _, c := range s.conns {
+		c.resync()
+	}
+	s.connMut.Unlock()
+}
+
+// MemoryBackplane is a Backplane for States within the same process.
+// Each subscriber receives publications in order, on its own goroutine.
+type MemoryBackplane struct {
+	mut    sync.Mutex
+	nextID int
+	subs   map[string]map[int]*memorySub
+	latest map[string]*Publication
+}
+
+// NewMemoryBackplane returns an empty MemoryBackplane
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{
+		subs:   map[string]map[int]*memorySub{},
+		latest: map[string]*Publication{},
+	}
+}
+
+func (m *MemoryBackplane) Publish(pub *Publication) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.latest[pub.Topic] = pub
+	for _, sub := range m.subs[pub.Topic] {
+		sub.deliver(pub)
+	}
+	return nil
+}
+
+func (m *MemoryBackplane) Subscribe(topic string, fn func(*Publication)) (func(), error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.nextID++
+	id := m.nextID
+	sub := &memorySub{fn: fn}
+	if m.subs[topic] == nil {
+		m.subs[topic] = map[int]*memorySub{}
+	}
+	m.subs[topic][id] = sub
+	if latest := m.latest[topic]; latest != nil {
+		sub.deliver(latest)
+	}
+	return func() {
+		m.mut.Lock()
+		delete(m.subs[topic], id)
+		m.mut.Unlock()
+	}, nil
+}
+
+// memorySub queues publications for a subscriber, so
+// that publishing never waits on a subscriber
+type memorySub struct {
+	fn      func(*Publication)
+	mut     sync.Mutex
+	queue   []*Publication
+	running bool
+}
+
+func (m *memorySub) deliver(pub *Publication) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.queue = append(m.queue, pub)
+	if !m.running {
+		m.running = true
+		go m.run()
+	}
+}
+
+func (m *memorySub) run() {
+	for {
+		m.mut.Lock()
+		if len(m.queue) == 0 {
+			m.running = false
+			m.mut.Unlock()
+			return
+		}
+		pub := m.queue[0]
+		m.queue = m.queue[1:]
+		m.mut.Unlock()
+		m.fn(pub)
+	}
+}
@@ -0,0 +1,216 @@
+package velox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// TCPBackplaneServer relays publications between TCPBackplane
+// clients as newline-delimited JSON. It is intended for tests and
+// for processes on a single host, it has no authentication.
+type TCPBackplaneServer struct {
+	// Logger optionally replaces slog.Default, set it before
+	// clients connect.
+	Logger *slog.Logger
+	l      net.Listener
+	mut    sync.Mutex
+	conns  map[net.Conn]*sync.Mutex
+	latest map[string]*Publication
+}
+
+// ListenTCPBackplane starts a TCPBackplaneServer on the given address
+func ListenTCPBackplane(addr string) (*TCPBackplaneServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &TCPBackplaneServer{
+		l:      l,
+		conns:  map[net.Conn]*sync.Mutex{},
+		latest: map[string]*Publication{},
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Addr is the listening address of the server
+func (s *TCPBackplaneServer) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and disconnects all clients
+func (s *TCPBackplaneServer) Close() error {
+	err := s.l.Close()
+	s.mut.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mut.Unlock()
+	return err
+}
+
+func (s *TCPBackplaneServer) accept() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mut.Lock()
+		wmut := &sync.Mutex{}
+		s.conns[c] = wmut
+		//bring the new client up to date
+		for _, pub := range s.latest {
+			writePublication(c, wmut, pub)
+		}
+		s.mut.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *TCPBackplaneServer) handle(c net.Conn) {
+	defer func() {
+		s.mut.Lock()
+		delete(s.conns, c)
+		s.mut.Unlock()
+		c.Close()
+	}()
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(nil, maxPublicationSize)
+	for scanner.Scan() {
+		pub := &Publication{}
+		if err := json.Unmarshal(scanner.Bytes(), pub); err != nil {
+			s.logger().Warn("velox: backplane server: invalid publication", "addr", c.RemoteAddr().String(), "err", err)
+			continue
+		}
+		s.mut.Lock()
+		s.latest[pub.Topic] = pub
+		for other, wmut := range s.conns {
+			if other != c {
+				writePublication(other, wmut, pub)
+			}
+		}
+		s.mut.Unlock()
+	}
+}
+
+func (s *TCPBackplaneServer) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// maxPublicationSize limits a single line on the wire
+const maxPublicationSize = 64 * 1024 * 1024
+
+func writePublication(c net.Conn, wmut *sync.Mutex, pub *Publication) error {
+	b, err := json.Marshal(pub)
+	if err != nil {
+		return err
+	}
+	wmut.Lock()
+	defer wmut.Unlock()
+	_, err = c.Write(append(b, '\n'))
+	return err
+}
+
+// TCPBackplane is a Backplane client of a TCPBackplaneServer
+type TCPBackplane struct {
+	// Logger optionally replaces slog.Default, set it before
+	// the server sends any publications.
+	Logger *slog.Logger
+	conn   net.Conn
+	wmut   sync.Mutex
+	mut    sync.Mutex
+	nextID int
+	subs   map[string]map[int]*memorySub
+	latest map[string]*Publication
+}
+
+// DialTCPBackplane connects to the TCPBackplaneServer at the given address
+func DialTCPBackplane(addr string) (*TCPBackplane, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &TCPBackplane{
+		conn:   c,
+		subs:   map[string]map[int]*memorySub{},
+		latest: map[string]*Publication{},
+	}
+	go b.read()
+	return b, nil
+}
+
+// Close disconnects from the server
+func (b *TCPBackplane) Close() error {
+	return b.conn.Close()
+}
+
+func (b *TCPBackplane) Publish(pub *Publication) error {
+	if err := writePublication(b.conn, &b.wmut, pub); err != nil {
+		return err
+	}
+	b.mut.Lock()
+	b.latest[pub.Topic] = pub
+	b.mut.Unlock()
+	return nil
+}
+
+func (b *TCPBackplane) Subscribe(topic string, fn func(*Publication)) (func(), error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.subs == nil {
+		return nil, errors.New("backplane closed")
+	}
+	b.nextID++
+	id := b.nextID
+	sub := &memorySub{fn: fn}
+	if b.subs[topic] == nil {
+		b.subs[topic] = map[int]*memorySub{}
+	}
+	b.subs[topic][id] = sub
+	if latest := b.latest[topic]; latest != nil {
+		sub.deliver(latest)
+	}
+	return func() {
+		b.mut.Lock()
+		delete(b.subs[topic], id)
+		b.mut.Unlock()
+	}, nil
+}
+
+func (b *TCPBackplane) read() {
+	scanner := bufio.NewScanner(b.conn)
+	scanner.Buffer(nil, maxPublicationSize)
+	for scanner.Scan() {
+		pub := &Publication{}
+		if err := json.Unmarshal(scanner.Bytes(), pub); err != nil {
+			b.logger().Warn("velox: backplane: invalid publication", "addr", b.conn.RemoteAddr().String(), "err", err)
+			continue
+		}
+		b.mut.Lock()
+		b.latest[pub.Topic] = pub
+		for _, sub := range b.subs[pub.Topic] {
+			sub.deliver(pub)
+		}
+		b.mut.Unlock()
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		b.logger().Error("velox: backplane disconnected", "addr", b.conn.RemoteAddr().String(), "err", err)
+	}
+	b.mut.Lock()
+	b.subs = nil
+	b.mut.Unlock()
+}
+
+func (b *TCPBackplane) logger() *slog.Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,177 @@
+package velox_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestBackplane(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		bp := velox.NewMemoryBackplane()
+		testBackplane(t, bp, bp)
+		testBackplaneConcurrent(t, bp, bp)
+	})
+	t.Run("tcp", func(t *testing.T) {
+		hub, err := velox.ListenTCPBackplane("127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer hub.Close()
+		leader, err := velox.DialTCPBackplane(hub.Addr())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer leader.Close()
+		follower, err := velox.DialTCPBackplane(hub.Addr())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer follower.Close()
+		testBackplane(t, leader, follower)
+		testBackplaneConcurrent(t, leader, follower)
+	})
+}
+
+func testBackplane(t *testing.T, leaderBP, followerBP velox.Backplane) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Count   int    `json:"count"`
+		Padding string `json:"padding"`
+	}
+	leader := &TestStruct{Padding: "some data which is not changing between versions"}
+	leader.State.Throttle = 10 * time.Millisecond
+	leader.State.Backplane = leaderBP
+	velox.SyncHandler(leader)
+	leader.Push()
+	// the follower has no data of its own
+	follower := &velox.State{Backplane: followerBP}
+	server := httptest.NewServer(follower)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := &testClient{url: server.URL}
+	if err := c.connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.disconnect()
+	next := func() *velox.Update {
+		for {
+			u, _, err := c.next()
+			if err != nil {
+				t.Fatalf("Failed to get update: %v", err)
+			}
+			if !u.Ping {
+				return u
+			}
+		}
+	}
+	// initial state, with the leader's id and version
+	u := next()
+	if u.ID != leader.State.ID() || u.Version != leader.State.Version() || u.Delta {
+		t.Fatalf("Expected full state %s/%d, got %+v", leader.State.ID(), leader.State.Version(), u)
+	}
+	// changes arrive as deltas
+	leader.Lock()
+	leader.Count = 42
+	leader.Unlock()
+	leader.Push()
+	u = next()
+	if !u.Delta || u.Version != 2 || string(u.Body) != `{"count":42}` {
+		t.Fatalf("Expected delta at version 2, got %+v (%s)", u, u.Body)
+	}
+}
+
+func testBackplaneConcurrent(t *testing.T, bpA, bpB velox.Backplane) {
+	type Replica struct {
+		velox.State
+		sync.Mutex
+		Count int               `json:"count"`
+		Names map[string]string `json:"names"`
+	}
+	newReplica := func(bp velox.Backplane) (*Replica, *ClientData, func()) {
+		r := &Replica{Names: map[string]string{}}
+		r.State.Throttle = velox.MinThrottle
+		r.State.Backplane = bp
+		r.State.BackplaneTopic = "concurrent"
+		server := httptest.NewServer(velox.SyncHandler(r))
+		clientData := &ClientData{}
+		client, err := velox.NewClient(server.URL, clientData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Retry = false
+		ctx, cancel := context.WithCancel(context.Background())
+		go client.Connect(ctx)
+		return r, clientData, func() {
+			cancel()
+			client.Disconnect()
+			server.Close()
+		}
+	}
+	a, clientA, closeA := newReplica(bpA)
+	defer closeA()
+	b, clientB, closeB := newReplica(bpB)
+	defer closeB()
+	// replicas and their clients converge on the same state
+	converged := func() bool {
+		if a.State.ID() != b.State.ID() || a.State.Version() != b.State.Version() {
+			return false
+		}
+		a.Lock()
+		count, names := a.Count, fmt.Sprint(a.Names)
+		a.Unlock()
+		b.Lock()
+		defer b.Unlock()
+		if b.Count != count || fmt.Sprint(b.Names) != names {
+			return false
+		}
+		for _, c := range []*ClientData{clientA, clientB} {
+			c.Lock()
+			ok := c.Count == count
+			c.Unlock()
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+	waitFor(t, "initial state", converged)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 1; i <= 5; i++ {
+		var wg sync.WaitGroup
+		for _, r := range []*Replica{a, b} {
+			wg.Go(func() {
+				r.Lock()
+				r.Count = i
+				r.Names[r.State.ID()] = "pushed"
+				r.Unlock()
+				if _, err := r.State.PushAndWait(ctx); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		wg.Wait()
+		waitFor(t, fmt.Sprintf("round %d", i), converged)
+	}
+	// adopted versions are written into the data, so
+	// the next push builds on the other replica's change
+	b.Lock()
+	names := len(b.Names)
+	b.Count = 100
+	b.Unlock()
+	b.Push()
+	waitFor(t, "later push", func() bool {
+		a.Lock()
+		defer a.Unlock()
+		return a.Count == 100 && len(a.Names) == names
+	})
+	waitFor(t, "later push converged", converged)
+}
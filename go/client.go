@@ -1,19 +1,17 @@
 package velox
 
 import (
-	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strconv"
 	"sync"
 	"time"
-
-	"github.com/jpillora/eventsource"
 )
 
 // Client connects to a Velox server and keeps a local struct in sync.
@@ -22,12 +20,47 @@ type Client[T any] struct {
 	URL string
 	// HTTPClient is the HTTP client to use (optional, useful for testing)
 	HTTPClient *http.Client
+	// Transport selects the connection transport (default: TransportSSE).
+	// TransportAuto tries WebSockets, falling back to SSE when the
+	// handshake fails, for example behind proxies which do not support
+	// WebSockets. Calling actions requires a WebSocket connection.
+	Transport Transport
+	// HandshakeTimeout bounds each WebSocket handshake, so TransportAuto
+	// falls back quickly behind proxies which hold upgrades open (default: 10s).
+	HandshakeTimeout time.Duration
+	// FallbackDuration is how long TransportAuto connects with SSE after
+	// a WebSocket handshake fails, before trying WebSockets again (default: 5m).
+	FallbackDuration time.Duration
+	// PingInterval is how often WebSocket connections ping the server,
+	// which closes connections it has not heard from in 30s (default: 25s).
+	PingInterval time.Duration
+	// Codec optionally replaces encoding/json for unmarshalling
+	// updates and (un)marshalling the data struct.
+	Codec Codec
+	// Encoding optionally requests a binary encoding, such as MsgPack
+	// or CBOR, when using TransportWebSocket. Servers without it use JSON.
+	Encoding Encoding
+	// DeltaFormat requests the format of delta updates (default: DeltaMergePatch).
+	// Updates in either format are applied.
+	DeltaFormat DeltaFormat
+	// Ack confirms each applied version to the server, which resyncs
+	// clients that stop confirming. Versions are only confirmed over
+	// websockets, so Ack has no effect on SSE connections.
+	Ack bool
+	// Logger optionally receives connection and update records (default: none)
+	Logger *slog.Logger
 
 	// Callbacks
 	OnUpdate     func() // Called after data is updated (outside lock)
 	OnConnect    func()
 	OnDisconnect func()
 	OnError      func(err error)
+	// OnEvent is called with each named event sent by
+	// State.Broadcast or Conn.Send (outside lock)
+	OnEvent func(name string, payload json.RawMessage)
+	// OnChange is called with the paths changed by each update,
+	// see Subscribe (outside lock)
+	OnChange func(paths []string)
 
 	// Retry enables automatic reconnection with backoff (default: true)
 	Retry bool
@@ -38,16 +71,28 @@ type Client[T any] struct {
 
 	// internal state
 	mu        sync.Mutex
-	data      *T               // pointer to user's struct
-	locker    sync.Locker      // non-nil if data implements sync.Locker
-	stateMap  map[string]any   // cached unmarshaled state for fast delta merge
-	id        string           // server-assigned state ID
-	version   int64            // current version
+	data      *T             // pointer to user's struct
+	locker    sync.Locker    // non-nil if data implements sync.Locker
+	stateMap  map[string]any // cached unmarshaled state for fast delta merge
+	id        string         // server-assigned state ID
+	version   int64          // current version
 	connected bool
-	body      io.ReadCloser
-	dec       *eventsource.Decoder
+	active    Transport              // transport of the current connection
+	fallback  time.Time              // TransportAuto connects with SSE until then
+	renderMu  sync.Mutex             // serialises updates to data
+	proposals []*clientProposal      // pending optimistic changes
+	conn      clientConn             // current connection
+	calls     map[int64]chan *Update // pending action replies
+	subs      []*pathSub             // path subscriptions
+	updates   []*snapshotSub[T]      // channels returned by Updates
+	callID    int64                  // last action id
 	cancel    context.CancelFunc
 	done      chan struct{}
+	// latest data, for snapshots
+	snapshot struct {
+		version int64
+		state   json.RawMessage // applied state, never modified
+	}
 }
 
 // NewClient creates a new Velox client that syncs to the given struct pointer.
@@ -95,6 +140,17 @@ func (c *Client[T]) Version() int64 {
 	return c.version
 }
 
+// ActiveTransport returns the transport of the current
+// connection, or an empty string when disconnected.
+func (c *Client[T]) ActiveTransport() Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return ""
+	}
+	return c.active
+}
+
 // Connected returns true if the client is currently connected.
 func (c *Client[T]) Connected() bool {
 	c.mu.Lock()
@@ -136,6 +192,25 @@ func (c *Client[T]) Connect(ctx context.Context) error {
 			return ctx.Err()
 		}
 
+		// Server shut down, wait as long as it asked
+		var bye *goodbyeError
+		if errors.As(err, &bye) {
+			if !c.Retry {
+				return err
+			}
+			c.logger().Info("velox: server shutting down", "url", c.URL, "retry", bye.retry)
+			retryDelay = c.MinRetryDelay
+			if retryDelay == 0 {
+				retryDelay = 100 * time.Millisecond
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(bye.retry):
+			}
+			continue
+		}
+
 		// Call error callback
 		if c.OnError != nil {
 			c.OnError(err)
@@ -143,14 +218,22 @@ func (c *Client[T]) Connect(ctx context.Context) error {
 
 		// Don't retry if disabled
 		if !c.Retry {
+			c.logger().Error("velox: connection failed", "url", c.URL, "err", err)
 			return err
 		}
+		// Refused connections wait at least as long as the server asked
+		delay := retryDelay
+		var status *StatusError
+		if errors.As(err, &status) {
+			delay = max(delay, status.RetryAfter)
+		}
+		c.logger().Warn("velox: connection failed, retrying", "url", c.URL, "delay", delay, "err", err)
 
 		// Wait before retrying
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(retryDelay):
+		case <-time.After(delay):
 		}
 
 		// Exponential backoff
@@ -185,80 +268,88 @@ func (c *Client[T]) connectOnce(ctx context.Context) error {
 	}
 
 	c.mu.Lock()
+	q := u.Query()
 	if c.version > 0 {
-		q := u.Query()
 		q.Set("v", strconv.FormatInt(c.version, 10))
 		if c.id != "" {
 			q.Set("id", c.id)
 		}
-		u.RawQuery = q.Encode()
 	}
-	c.mu.Unlock()
-
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if c.Ack && c.transport() != TransportSSE {
+		q.Set("ack", "1")
 	}
-	req.Header.Set("Accept", "text/event-stream")
-	req.Header.Set("Accept-Encoding", "gzip")
-
-	// Make request
-	httpClient := c.HTTPClient
-	if httpClient == nil {
-		httpClient = http.DefaultClient
-	}
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("HTTP request failed: %w", err)
-	}
-
-	// Check response
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
-	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
-		resp.Body.Close()
-		return fmt.Errorf("unexpected content-type: %s", ct)
+	if c.DeltaFormat != "" && c.DeltaFormat != DeltaMergePatch {
+		q.Set("format", string(c.DeltaFormat))
 	}
+	u.RawQuery = q.Encode()
+	c.mu.Unlock()
 
-	// Wrap body with gzip reader if server sent compressed response
-	var bodyReader io.Reader = resp.Body
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			resp.Body.Close()
-			return fmt.Errorf("failed to create gzip reader: %w", err)
+	transport := c.transport()
+	var conn clientConn
+	switch transport {
+	case TransportWebSocket:
+		conn, err = c.dialWebSocket(ctx, u)
+	case TransportAuto:
+		transport = TransportWebSocket
+		c.mu.Lock()
+		fallback := time.Now().Before(c.fallback)
+		c.mu.Unlock()
+		if !fallback {
+			conn, err = c.dialWebSocket(ctx, u)
+			fallback = err != nil && ctx.Err() == nil && fallbackToSSE(err)
+			if fallback {
+				c.logger().Warn("velox: websocket failed, falling back to sse", "url", c.URL, "err", err)
+				c.mu.Lock()
+				c.fallback = time.Now().Add(c.fallbackDuration())
+				c.mu.Unlock()
+			}
 		}
-		bodyReader = gzReader
-		resp.Body = &gzipReadCloser{gzReader: gzReader, body: resp.Body}
+		if fallback {
+			transport = TransportSSE
+			// versions are not acknowledged over sse
+			sse := *u
+			q := sse.Query()
+			q.Del("ack")
+			sse.RawQuery = q.Encode()
+			conn, err = c.dialEventSource(ctx, &sse)
+		}
+	default:
+		conn, err = c.dialEventSource(ctx, u)
+	}
+	if err != nil {
+		return err
 	}
 
 	c.mu.Lock()
-	c.body = resp.Body
-	c.dec = eventsource.NewDecoder(bodyReader)
+	c.conn = conn
 	c.connected = true
+	c.active = transport
 	c.mu.Unlock()
 
+	c.logger().Info("velox: connected", "url", c.URL, "transport", transport)
+
 	// Notify connect
 	if c.OnConnect != nil {
 		c.OnConnect()
 	}
 
-	// Read events
-	err = c.readEvents(ctx)
+	// Read events, acknowledging versions over websockets only
+	err = c.readEvents(ctx, conn, c.Ack && transport == TransportWebSocket)
 
 	// Cleanup
 	c.mu.Lock()
 	c.connected = false
-	if c.body != nil {
-		c.body.Close()
-		c.body = nil
+	conn.close()
+	c.conn = nil
+	// Fail pending calls, their replies will never arrive
+	for id, ch := range c.calls {
+		close(ch)
+		delete(c.calls, id)
 	}
-	c.dec = nil
 	c.mu.Unlock()
 
+	c.logger().Info("velox: disconnected", "url", c.URL, "err", err)
+
 	// Notify disconnect
 	if c.OnDisconnect != nil {
 		c.OnDisconnect()
@@ -267,8 +358,9 @@ func (c *Client[T]) connectOnce(ctx context.Context) error {
 	return err
 }
 
-// readEvents reads and processes events from the SSE stream.
-func (c *Client[T]) readEvents(ctx context.Context) error {
+// readEvents reads and processes updates from the connection,
+// confirming applied versions when ack is set.
+func (c *Client[T]) readEvents(ctx context.Context, conn clientConn, ack bool) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -276,32 +368,16 @@ func (c *Client[T]) readEvents(ctx context.Context) error {
 		default:
 		}
 
-		c.mu.Lock()
-		dec := c.dec
-		c.mu.Unlock()
-
-		if dec == nil {
-			return nil
-		}
-
-		e := &eventsource.Event{}
-		if err := dec.Decode(e); err != nil {
-			if err == io.EOF {
-				// If context was cancelled, treat as clean shutdown
-				select {
-				case <-ctx.Done():
-					return nil
-				default:
-				}
-				// Otherwise return error so retry loop can reconnect
-				return fmt.Errorf("event stream closed unexpectedly: %w", err)
+		update, err := conn.next()
+		if err != nil {
+			// If context was cancelled, treat as clean shutdown
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
 			}
-			return fmt.Errorf("failed to decode event: %w", err)
-		}
-
-		update := &Update{}
-		if err := json.Unmarshal([]byte(e.Data), update); err != nil {
-			return fmt.Errorf("failed to unmarshal update: %w", err)
+			// Otherwise return error so retry loop can reconnect
+			return err
 		}
 
 		// Handle ping
@@ -309,80 +385,289 @@ func (c *Client[T]) readEvents(ctx context.Context) error {
 			continue
 		}
 
-		// Update metadata
-		c.mu.Lock()
-		if update.ID != "" {
-			c.id = update.ID
-		}
-		if update.Version > 0 {
-			c.version = update.Version
-		}
-
-		// Apply update to internal state tracker
-		var newState json.RawMessage
-		if len(update.Body) == 0 {
-			// Treat empty body as explicit state clear
-			c.stateMap = nil
-		} else if update.Delta && c.stateMap != nil {
-			// Apply delta patch in-place using mergeObjects (zero-alloc)
-			var patchMap map[string]any
-			if err := json.Unmarshal(update.Body, &patchMap); err != nil {
-				c.mu.Unlock()
-				if c.OnError != nil {
-					c.OnError(fmt.Errorf("failed to unmarshal patch: %w", err))
-				}
-				continue
+		// Server is shutting down, reconnect when asked
+		if update.Goodbye {
+			return &goodbyeError{retry: time.Duration(update.Retry) * time.Millisecond}
+		}
+
+		// Out-of-band events
+		if update.Event != "" {
+			if c.OnEvent != nil {
+				c.OnEvent(update.Event, update.Payload)
 			}
-			mergeObjects(c.stateMap, patchMap)
-			// Marshal the updated map to bytes for struct unmarshal
-			merged, err := json.Marshal(c.stateMap)
-			if err != nil {
-				c.mu.Unlock()
-				if c.OnError != nil {
-					c.OnError(fmt.Errorf("failed to marshal state: %w", err))
-				}
-				continue
+			continue
+		}
+
+		// Handle action replies
+		if update.Reply != 0 {
+			c.mu.Lock()
+			if ch, ok := c.calls[update.Reply]; ok {
+				ch <- update
+				delete(c.calls, update.Reply)
 			}
-			newState = merged
-		} else {
-			// Full state replacement — cache as map for future deltas
-			var m map[string]any
-			if err := json.Unmarshal(update.Body, &m); err == nil {
-				c.stateMap = m
+			c.mu.Unlock()
+			continue
+		}
+
+		paths, updated, applied, err := c.applyUpdate(update)
+		if err != nil {
+			// out of sync, reconnect to resync the full state
+			return err
+		}
+		if applied && ack && update.Version > 0 {
+			// Confirm the applied version
+			if err := conn.send(&Message{Ack: update.Version}); err != nil {
+				return err
 			}
-			newState = update.Body
 		}
+		if updated && c.OnUpdate != nil {
+			// Notify update (outside lock)
+			c.OnUpdate()
+		}
+		if updated {
+			c.notifyPaths(paths)
+		}
+	}
+}
+
+// applyUpdate applies a state update from the server to the data struct,
+// returning the paths it changed, whether the struct was updated and
+// whether the update applied. An error is returned when a delta cannot
+// be applied, the state is then out of sync until it is resent in full.
+func (c *Client[T]) applyUpdate(update *Update) (paths []string, updated, applied bool, err error) {
+	c.renderMu.Lock()
+	defer c.renderMu.Unlock()
+
+	if l := c.logger(); debugging(l) {
+		l.Debug("velox: update", "id", update.ID, "version", update.Version, "delta", update.Delta, "bytes", len(update.Body))
+	}
+
+	// Update metadata
+	c.mu.Lock()
+	if update.ID != "" {
+		c.id = update.ID
+	}
+	if update.Version > 0 {
+		c.version = update.Version
+	}
+
+	// Apply update to internal state tracker
+	codec := c.codec()
+	var newState json.RawMessage
+	if len(update.Body) == 0 {
+		// Treat empty body as explicit state clear
+		paths = sortedKeys(c.stateMap)
+		c.stateMap = nil
+	} else if update.Delta && c.stateMap != nil && update.Format == DeltaJSONPatch {
+		ops, err := decodePatchOps(codec, update.Body)
+		if err != nil {
+			// out of sync, resume with the full state
+			c.stateMap, c.version = nil, 0
+			c.mu.Unlock()
+			return nil, false, false, fmt.Errorf("failed to unmarshal patch: %w", err)
+		}
+		paths = opPaths(ops)
+		doc, err := applyJSONPatch(c.stateMap, ops)
+		m, ok := doc.(map[string]any)
+		if err == nil && !ok {
+			err = errors.New("not an object")
+		}
+		if err != nil {
+			// out of sync, resume with the full state
+			c.stateMap, c.version = nil, 0
+			c.mu.Unlock()
+			return nil, false, false, fmt.Errorf("failed to apply patch: %w", err)
+		}
+		c.stateMap = m
+		merged, err := codec.Marshal(c.stateMap)
+		if err != nil {
+			c.mu.Unlock()
+			c.onError(fmt.Errorf("failed to marshal state: %w", err))
+			return nil, false, false, nil
+		}
+		newState = merged
+	} else if update.Delta && c.stateMap != nil {
+		// Apply delta patch in-place using mergeObjects (zero-alloc)
+		var patchMap map[string]any
+		if err := codec.Unmarshal(update.Body, &patchMap); err != nil {
+			// out of sync, resume with the full state
+			c.stateMap, c.version = nil, 0
+			c.mu.Unlock()
+			return nil, false, false, fmt.Errorf("failed to unmarshal patch: %w", err)
+		}
+		paths = patchPaths(nil, "", patchMap)
+		mergeObjects(c.stateMap, patchMap)
+		// Marshal the updated map to bytes for struct unmarshal
+		merged, err := codec.Marshal(c.stateMap)
+		if err != nil {
+			c.mu.Unlock()
+			c.onError(fmt.Errorf("failed to marshal state: %w", err))
+			return nil, false, false, nil
+		}
+		newState = merged
+	} else {
+		// Full state replacement — cache as map for future deltas
+		var m map[string]any
+		if err := codec.Unmarshal(update.Body, &m); err == nil {
+			paths = patchPaths(nil, "", objectDiff(c.stateMap, m))
+			c.stateMap = m
+		}
+		newState = update.Body
+	}
+	// Pending proposals remain applied on top of the server state,
+	// snapshots are of the server state alone
+	server := newState
+	newState = c.withProposals(newState)
+	c.mu.Unlock()
+
+	if len(newState) == 0 {
+		c.setSnapshot(server)
+		return paths, false, true, nil
+	}
+	updated = c.setData(newState)
+	if updated {
+		c.setSnapshot(server)
+	}
+	return paths, updated, updated, nil
+}
+
+// setData replaces the contents of the data struct (with locking if
+// supported). Zero all serializable fields before unmarshaling to ensure
+// fields removed from the stateMap (via omitzero/omitempty) are properly
+// cleared.
+func (c *Client[T]) setData(newState json.RawMessage) bool {
+	codec := c.codec()
+	if c.locker != nil {
+		c.locker.Lock()
+	}
+	clearForUnmarshal(c.data)
+	if err := codec.Unmarshal(newState, c.data); err != nil {
+		if c.locker != nil {
+			c.locker.Unlock()
+		}
+		c.onError(fmt.Errorf("failed to unmarshal into data: %w", err))
+		return false
+	}
+	// Bind all VMap/VSlice fields (nil pusher on client)
+	bindAll(c.data, c.locker, nil)
+	if c.locker != nil {
+		c.locker.Unlock()
+	}
+	return true
+}
+
+// goodbyeError is returned when the server shuts down
+type goodbyeError struct {
+	retry time.Duration
+}
+
+func (g *goodbyeError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrShutdown, g.retry)
+}
+
+func (g *goodbyeError) Unwrap() error {
+	return ErrShutdown
+}
+
+// transport used by this client
+func (c *Client[T]) transport() Transport {
+	if c.Transport == "" {
+		return TransportSSE
+	}
+	return c.Transport
+}
+
+// fallbackDuration is how long TransportAuto uses SSE after a failure
+func (c *Client[T]) fallbackDuration() time.Duration {
+	if c.FallbackDuration <= 0 {
+		return clientFallbackDuration
+	}
+	return c.FallbackDuration
+}
+
+// fallbackToSSE reports whether a failed websocket connection should
+// be retried with SSE, which servers refusing the client would refuse too
+func fallbackToSSE(err error) bool {
+	var status *StatusError
+	if !errors.As(err, &status) {
+		return true
+	}
+	switch status.Code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return false
+	}
+	return true
+}
+
+// codec used by this client
+func (c *Client[T]) codec() Codec {
+	return codecOr(c.Codec)
+}
+
+func (c *Client[T]) onError(err error) {
+	c.logger().Error("velox: update failed", "url", c.URL, "err", err)
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// Call invokes the named action on the server and waits for its reply,
+// which is unmarshaled into result when non-nil. Server-side failures
+// are returned as an *ActionError. Actions require TransportWebSocket.
+func (c *Client[T]) Call(ctx context.Context, action string, payload any, result any) error {
+	codec := c.codec()
+	var raw json.RawMessage
+	if payload != nil {
+		b, err := codec.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		raw = b
+	}
+	c.mu.Lock()
+	conn := c.conn
+	if conn == nil {
 		c.mu.Unlock()
+		return fmt.Errorf("not connected")
+	}
+	c.callID++
+	id := c.callID
+	ch := make(chan *Update, 1)
+	if c.calls == nil {
+		c.calls = map[int64]chan *Update{}
+	}
+	c.calls[id] = ch
+	c.mu.Unlock()
 
-		// Apply to user's data struct (with locking if supported)
-		// Zero all serializable fields before unmarshaling to ensure fields
-		// removed from the stateMap (via omitzero/omitempty) are properly cleared.
-		if len(newState) > 0 {
-			if c.locker != nil {
-				c.locker.Lock()
-			}
-			clearForUnmarshal(c.data)
-			if err := json.Unmarshal(newState, c.data); err != nil {
-				if c.locker != nil {
-					c.locker.Unlock()
-				}
-				if c.OnError != nil {
-					c.OnError(fmt.Errorf("failed to unmarshal into data: %w", err))
-				}
-				continue
-			}
-			// Bind all VMap/VSlice fields (nil pusher on client)
-			bindAll(c.data, c.locker, nil)
-			if c.locker != nil {
-				c.locker.Unlock()
-			}
+	if err := conn.send(&Message{ID: id, Action: action, Payload: raw}); err != nil {
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return err
+	}
 
-			// Notify update (outside lock)
-			if c.OnUpdate != nil {
-				c.OnUpdate()
-			}
+	var reply *Update
+	select {
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	case r, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("disconnected")
+		}
+		reply = r
+	}
+	if reply.Error != "" {
+		return &ActionError{Action: action, Message: reply.Error, Conflict: reply.Conflict}
+	}
+	if result != nil && len(reply.Payload) > 0 {
+		if err := codec.Unmarshal(reply.Payload, result); err != nil {
+			return fmt.Errorf("failed to unmarshal result: %w", err)
 		}
 	}
+	return nil
 }
 
 // clearForUnmarshal zeros all JSON-serializable fields in a struct before
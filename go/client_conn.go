@@ -0,0 +1,245 @@
+package velox
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/eventsource"
+)
+
+// clientPingInterval is the default Client.PingInterval,
+// the server times out websocket reads after 30s.
+const clientPingInterval = 25 * time.Second
+
+// clientHandshakeTimeout is the default Client.HandshakeTimeout
+const clientHandshakeTimeout = 10 * time.Second
+
+// clientFallbackDuration is the default Client.FallbackDuration
+const clientFallbackDuration = 5 * time.Minute
+
+// clientConn is a single connection from a Client to the server
+type clientConn interface {
+	next() (*Update, error)
+	send(msg *Message) error
+	close() error
+}
+
+// dialEventSource opens an SSE stream to the server
+func (c *Client[T]) dialEventSource(ctx context.Context, u *url.URL) (clientConn, error) {
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// Make request
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+
+	// Check response
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected content-type: %s", ct)
+	}
+
+	// Wrap body with gzip reader if server sent compressed response
+	var bodyReader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		bodyReader = gzReader
+		resp.Body = &gzipReadCloser{gzReader: gzReader, body: resp.Body}
+	}
+	return &sseClientConn{
+		body:  resp.Body,
+		dec:   eventsource.NewDecoder(bodyReader),
+		codec: c.codec(),
+	}, nil
+}
+
+type sseClientConn struct {
+	body  io.ReadCloser
+	dec   *eventsource.Decoder
+	codec Codec
+}
+
+func (s *sseClientConn) next() (*Update, error) {
+	e := &eventsource.Event{}
+	if err := s.dec.Decode(e); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("event stream closed unexpectedly: %w", err)
+		}
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	update := &Update{}
+	if err := s.codec.Unmarshal(e.Data, update); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal update: %w", err)
+	}
+	return update, nil
+}
+
+func (s *sseClientConn) send(msg *Message) error {
+	return errors.New("sending requires the websocket transport")
+}
+
+func (s *sseClientConn) close() error {
+	return s.body.Close()
+}
+
+// dialWebSocket opens a websocket to the server, reusing the
+// dialer and TLS settings of the HTTPClient transport if any
+func (c *Client[T]) dialWebSocket(ctx context.Context, u *url.URL) (clientConn, error) {
+	wsURL := *u
+	switch u.Scheme {
+	case "http":
+		wsURL.Scheme = "ws"
+	case "https":
+		wsURL.Scheme = "wss"
+	}
+	timeout := c.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = clientHandshakeTimeout
+	}
+	dialer := &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  timeout,
+		EnableCompression: true,
+	}
+	if c.HTTPClient != nil {
+		if t, ok := c.HTTPClient.Transport.(*http.Transport); ok {
+			dialer.NetDialContext = t.DialContext
+			dialer.Proxy = t.Proxy
+			dialer.TLSClientConfig = t.TLSClientConfig
+		}
+		dialer.Jar = c.HTTPClient.Jar
+	}
+	if c.Encoding != nil {
+		dialer.Subprotocols = []string{subprotocol(c.Encoding)}
+	}
+	conn, resp, err := dialer.DialContext(ctx, wsURL.String(), nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("websocket handshake failed: %w", responseError(resp))
+		}
+		return nil, fmt.Errorf("websocket dial failed: %w", err)
+	}
+	ws := &wsClientConn{
+		conn:  conn,
+		done:  make(chan struct{}),
+		codec: c.codec(),
+	}
+	// servers which do not support the encoding use JSON
+	if c.Encoding != nil && conn.Subprotocol() == subprotocol(c.Encoding) {
+		ws.encoding = c.Encoding
+	}
+	// unblock reads when the context is cancelled
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.close()
+		case <-ws.done:
+		}
+	}()
+	interval := c.PingInterval
+	if interval <= 0 {
+		interval = clientPingInterval
+	}
+	go ws.pingLoop(interval)
+	return ws, nil
+}
+
+type wsClientConn struct {
+	conn      *websocket.Conn
+	writeMut  sync.Mutex // websocket allows one concurrent writer
+	closeOnce sync.Once
+	done      chan struct{}
+	encoding  Encoding // negotiated, nil for JSON
+	codec     Codec
+}
+
+func (w *wsClientConn) next() (*Update, error) {
+	t, b, err := w.conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("websocket closed unexpectedly: %w", err)
+	}
+	if t == websocket.BinaryMessage && w.encoding != nil {
+		if b, err = wireToJSON(w.codec, w.encoding, b); err != nil {
+			return nil, fmt.Errorf("failed to decode update: %w", err)
+		}
+	}
+	update := &Update{}
+	if err := w.codec.Unmarshal(b, update); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal update: %w", err)
+	}
+	return update, nil
+}
+
+func (w *wsClientConn) send(msg *Message) error {
+	if w.encoding != nil {
+		b, err := encodeWire(w.codec, w.encoding, msg, nil)
+		if err != nil {
+			return err
+		}
+		return w.write(websocket.BinaryMessage, b)
+	}
+	b, err := w.codec.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.write(websocket.TextMessage, b)
+}
+
+func (w *wsClientConn) write(messageType int, b []byte) error {
+	w.writeMut.Lock()
+	defer w.writeMut.Unlock()
+	return w.conn.WriteMessage(messageType, b)
+}
+
+// pingLoop keeps the connection alive until closed
+func (w *wsClientConn) pingLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := w.write(websocket.TextMessage, []byte("ping")); err != nil {
+				w.close()
+				return
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *wsClientConn) close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.conn.Close()
+	})
+	return err
+}
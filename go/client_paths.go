@@ -0,0 +1,126 @@
+package velox
+
+import (
+	"slices"
+	"strings"
+)
+
+// pathSub is a callback registered with Subscribe
+type pathSub struct {
+	pattern []string
+	fn      func(paths []string)
+}
+
+// Subscribe calls fn with the paths changed by each update which match
+// the pattern. Paths are JSON object keys joined with dots, and pattern
+// segments may be "*" to match any key, such as "Users.*.Status". Changes
+// within a matched path, and changes replacing or deleting one of its
+// parents, also match. Array elements are numbered within JSON Patch
+// deltas, while merge patches replace arrays as a whole. fn is called
+// outside the data lock, after OnUpdate. Call the returned func to
+// unsubscribe.
+func (c *Client[T]) Subscribe(pattern string, fn func(paths []string)) (unsubscribe func()) {
+	sub := &pathSub{pattern: splitPath(pattern), fn: fn}
+	c.mu.Lock()
+	c.subs = append(c.subs, sub)
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.subs = slices.DeleteFunc(c.subs, func(s *pathSub) bool { return s == sub })
+	}
+}
+
+// SubscribeChan is Subscribe, sending the matching paths to ch. Sends
+// do not block updates, so changes are dropped while ch is full.
+func (c *Client[T]) SubscribeChan(pattern string, ch chan<- []string) (unsubscribe func()) {
+	return c.Subscribe(pattern, func(paths []string) {
+		select {
+		case ch <- paths:
+		default:
+		}
+	})
+}
+
+// notifyPaths calls OnChange and the matching subscriptions
+func (c *Client[T]) notifyPaths(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	if c.OnChange != nil {
+		c.OnChange(paths)
+	}
+	c.mu.Lock()
+	subs := slices.Clone(c.subs)
+	c.mu.Unlock()
+	for _, sub := range subs {
+		var matched []string
+		for _, p := range paths {
+			if matchPath(sub.pattern, splitPath(p)) {
+				matched = append(matched, p)
+			}
+		}
+		if len(matched) > 0 {
+			sub.fn(matched)
+		}
+	}
+}
+
+// matchPath reports whether the pattern matches the path, a parent
+// of the path, or whether the path is a parent of the pattern
+func matchPath(pattern, path []string) bool {
+	for i := range min(len(pattern), len(path)) {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// patchPaths appends the paths of the values set or deleted by a merge
+// patch, sorted. Objects are changed key by key, so they are descended.
+func patchPaths(paths []string, prefix string, patch map[string]interface{}) []string {
+	for _, key := range sortedKeys(patch) {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if obj, ok := patch[key].(map[string]interface{}); ok && len(obj) > 0 {
+			paths = patchPaths(paths, path, obj)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// opPaths lists the paths changed by JSON Patch operations
+func opPaths(ops []patchOp) []string {
+	var paths []string
+	add := func(pointer string) {
+		tokens, err := parsePointer(pointer)
+		if err != nil {
+			return
+		}
+		if p := strings.Join(tokens, "."); !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case "test":
+			continue
+		case "move":
+			add(op.From)
+		}
+		add(op.Path)
+	}
+	return paths
+}
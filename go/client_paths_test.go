@@ -0,0 +1,120 @@
+package velox_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestClientSubscribe(t *testing.T) {
+	type User struct {
+		Name   string
+		Status string
+	}
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Users   map[string]User
+		Count   int
+		Padding string
+	}
+	for _, format := range []velox.DeltaFormat{velox.DeltaMergePatch, velox.DeltaJSONPatch} {
+		t.Run(string(format), func(t *testing.T) {
+			// padded, so that updates are sent as deltas
+			test := &TestStruct{Users: map[string]User{"alice": {"Alice", "away"}}, Padding: strings.Repeat("-", 200)}
+			test.State.Throttle = 10 * time.Millisecond
+			server := httptest.NewServer(velox.SyncHandler(test))
+			defer server.Close()
+			client, err := velox.NewClient(server.URL, &struct {
+				sync.Mutex
+				Users   map[string]User
+				Count   int
+				Padding string
+			}{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.DeltaFormat = format
+			client.Retry = false
+			changes := make(chan []string, 10)
+			client.OnChange = func(paths []string) { changes <- paths }
+			statuses := make(chan []string, 10)
+			unsubscribe := client.SubscribeChan("Users.*.Status", statuses)
+			counts := make(chan []string, 10)
+			client.Subscribe("Count", func(paths []string) { counts <- paths })
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			go client.Connect(ctx)
+			defer client.Disconnect()
+			next := func(ch chan []string) []string {
+				t.Helper()
+				select {
+				case paths := <-ch:
+					return paths
+				case <-time.After(5 * time.Second):
+					t.Fatal("Timed out waiting for paths")
+					return nil
+				}
+			}
+			// the initial state changes every path
+			if paths := next(changes); !reflect.DeepEqual(paths, []string{"Count", "Padding", "Users.alice.Name", "Users.alice.Status"}) {
+				t.Fatalf("Unexpected initial paths %v", paths)
+			}
+			if paths := next(statuses); !reflect.DeepEqual(paths, []string{"Users.alice.Status"}) {
+				t.Fatalf("Unexpected initial statuses %v", paths)
+			}
+			next(counts)
+			// a delta changes only its paths
+			test.Lock()
+			test.Users["alice"] = User{"Alice", "online"}
+			test.Unlock()
+			test.Push()
+			if paths := next(changes); !reflect.DeepEqual(paths, []string{"Users.alice.Status"}) {
+				t.Fatalf("Unexpected delta paths %v", paths)
+			}
+			if paths := next(statuses); !reflect.DeepEqual(paths, []string{"Users.alice.Status"}) {
+				t.Fatalf("Unexpected statuses %v", paths)
+			}
+			unsubscribe()
+			test.Lock()
+			test.Count++
+			test.Users["alice"] = User{"Alice", "away"}
+			test.Unlock()
+			test.Push()
+			if paths := next(counts); !reflect.DeepEqual(paths, []string{"Count"}) {
+				t.Fatalf("Unexpected counts %v", paths)
+			}
+			select {
+			case paths := <-statuses:
+				t.Fatalf("Unexpected statuses after unsubscribe %v", paths)
+			default:
+			}
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"Users.*.Status", "Users.alice.Status", true},
+		{"Users.*.Status", "Users.alice.Name", false},
+		{"Users.*.Status", "Users.alice", true},
+		{"Users.*.Status", "Users", true},
+		{"Users", "Users.alice.Status", true},
+		{"Users.alice", "Users.bob.Status", false},
+		{"Count", "Users.alice", false},
+		{"", "Count", true},
+	} {
+		if got := velox.MatchPath(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("MatchPath(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
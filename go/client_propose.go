@@ -0,0 +1,119 @@
+package velox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// clientProposal is an optimistic change which has not yet
+// been included in an update from the server
+type clientProposal struct {
+	patch json.RawMessage
+	after int64 // once accepted, the change is included after this version
+}
+
+// Propose optimistically applies a merge patch (RFC 7386) to the local data,
+// then proposes it to the server against the current version. The change
+// stays applied on top of server updates until the server includes it.
+// When the server rejects the change, it is rolled back and the error is
+// returned; errors.Is(err, ErrConflict) reports whether the data was changed
+// concurrently. The server must set State.Patch, and proposals require
+// TransportWebSocket.
+func (c *Client[T]) Propose(ctx context.Context, patch any) error {
+	codec := c.codec()
+	b, err := codec.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch: %w", err)
+	}
+	var m map[string]any
+	if err := codec.Unmarshal(b, &m); err != nil || m == nil {
+		return fmt.Errorf("patch must be a JSON object")
+	}
+	p := &clientProposal{patch: b}
+	c.mu.Lock()
+	base := c.version
+	c.proposals = append(c.proposals, p)
+	c.mu.Unlock()
+	c.render()
+
+	result := proposalResult{}
+	err = c.Call(ctx, ProposeAction, &proposal{Base: base, Patch: b}, &result)
+
+	c.mu.Lock()
+	if err != nil {
+		c.removeProposal(p)
+		c.mu.Unlock()
+		c.render() // roll back
+		return err
+	}
+	p.after = result.Version
+	if c.version > p.after {
+		c.removeProposal(p) // already included
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// render re-applies the server state and pending proposals to the data
+func (c *Client[T]) render() {
+	codec := c.codec()
+	c.renderMu.Lock()
+	c.mu.Lock()
+	var state json.RawMessage
+	if c.stateMap != nil {
+		state, _ = codec.Marshal(c.stateMap)
+	}
+	state = c.withProposals(state)
+	c.mu.Unlock()
+	//the server state is unchanged, so is its snapshot
+	updated := len(state) > 0 && c.setData(state)
+	c.renderMu.Unlock()
+	if updated && c.OnUpdate != nil {
+		c.OnUpdate()
+	}
+}
+
+// withProposals drops proposals which the server state now includes,
+// and returns the state with the remaining proposals applied. Requires c.mu.
+func (c *Client[T]) withProposals(state json.RawMessage) json.RawMessage {
+	pending := c.proposals[:0]
+	for _, p := range c.proposals {
+		if p.after == 0 || c.version <= p.after {
+			pending = append(pending, p)
+		}
+	}
+	c.proposals = pending
+	if len(pending) == 0 {
+		return state
+	}
+	codec := c.codec()
+	doc := map[string]any{}
+	if len(state) > 0 {
+		if err := codec.Unmarshal(state, &doc); err != nil {
+			return state
+		}
+	}
+	for _, p := range pending {
+		// unmarshal each time, merging may retain references to the patch
+		var patchMap map[string]any
+		if err := codec.Unmarshal(p.patch, &patchMap); err == nil {
+			mergeObjects(doc, patchMap)
+		}
+	}
+	b, err := codec.Marshal(doc)
+	if err != nil {
+		return state
+	}
+	return b
+}
+
+// removeProposal requires c.mu
+func (c *Client[T]) removeProposal(p *clientProposal) {
+	for i, q := range c.proposals {
+		if q == p {
+			c.proposals = append(c.proposals[:i], c.proposals[i+1:]...)
+			return
+		}
+	}
+}
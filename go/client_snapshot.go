@@ -0,0 +1,95 @@
+package velox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Snapshot is a copy of the server state at a version, which can be
+// read without locking. Unlike the Client's data, it does not include
+// pending proposals.
+type Snapshot[T any] struct {
+	Version int64
+	Data    *T
+}
+
+// snapshotSub is a channel returned by Updates
+type snapshotSub[T any] struct {
+	ch chan Snapshot[T]
+}
+
+// Snapshot returns a deep copy of the server state at its current version.
+// Before the first update, the version is zero and the data is empty.
+func (c *Client[T]) Snapshot() Snapshot[T] {
+	c.mu.Lock()
+	version, state := c.snapshot.version, c.snapshot.state
+	c.mu.Unlock()
+	return c.newSnapshot(version, state)
+}
+
+// Updates returns a channel receiving a Snapshot after each update from
+// the server, until ctx is done. Updates never wait for the consumer: once buffer
+// snapshots are pending, the oldest is dropped, so a slow consumer skips
+// to the latest version. A buffer less than 1 only keeps the latest.
+// Snapshots are shared between the channels returned by Updates, and
+// must not be modified.
+func (c *Client[T]) Updates(ctx context.Context, buffer int) <-chan Snapshot[T] {
+	sub := &snapshotSub[T]{ch: make(chan Snapshot[T], max(buffer, 1))}
+	c.mu.Lock()
+	c.updates = append(c.updates, sub)
+	c.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.updates = slices.DeleteFunc(c.updates, func(s *snapshotSub[T]) bool { return s == sub })
+		close(sub.ch)
+	}()
+	return sub.ch
+}
+
+// setSnapshot records the server state, and sends
+// a snapshot of it to Updates. Requires renderMu.
+func (c *Client[T]) setSnapshot(state json.RawMessage) {
+	c.mu.Lock()
+	c.snapshot.version = c.version
+	c.snapshot.state = state
+	version := c.version
+	subscribed := len(c.updates) > 0
+	c.mu.Unlock()
+	if !subscribed {
+		return
+	}
+	snap := c.newSnapshot(version, state)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, sub := range c.updates {
+		select {
+		case sub.ch <- snap:
+			continue
+		default:
+		}
+		//full, drop the oldest (the consumer may have taken it)
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- snap:
+		default:
+		}
+	}
+}
+
+// newSnapshot decodes a copy of the state
+func (c *Client[T]) newSnapshot(version int64, state json.RawMessage) Snapshot[T] {
+	data := new(T)
+	if len(state) > 0 {
+		if err := c.codec().Unmarshal(state, data); err != nil {
+			c.onError(fmt.Errorf("failed to unmarshal snapshot: %w", err))
+		}
+	}
+	return Snapshot[T]{Version: version, Data: data}
+}
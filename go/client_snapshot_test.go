@@ -0,0 +1,140 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestClientSnapshot(t *testing.T) {
+	serverData := &ServerData{Name: "snap", Count: 1}
+	serverData.State.Throttle = velox.MinThrottle
+	server := httptest.NewServer(velox.SyncHandler(serverData))
+	defer server.Close()
+	clientData := &ClientData{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap := client.Snapshot(); snap.Version != 0 || snap.Data == nil || snap.Data.Name != "" {
+		t.Fatalf("Expected empty snapshot, got %+v", snap)
+	}
+	client.Retry = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updates := client.Updates(ctx, 100)
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	next := func() velox.Snapshot[ClientData] {
+		t.Helper()
+		select {
+		case snap := <-updates:
+			return snap
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for snapshot")
+			return velox.Snapshot[ClientData]{}
+		}
+	}
+	if snap := next(); snap.Version != 1 || snap.Data.Name != "snap" || snap.Data.Count != 1 {
+		t.Fatalf("Unexpected initial snapshot %d %+v", snap.Version, snap.Data)
+	}
+	serverData.Lock()
+	serverData.Count = 2
+	serverData.Unlock()
+	serverData.Push()
+	snap := next()
+	if snap.Version != 2 || snap.Data.Count != 2 {
+		t.Fatalf("Unexpected snapshot %d %+v", snap.Version, snap.Data)
+	}
+	// snapshots are copies
+	current := client.Snapshot()
+	current.Data.Count = 100
+	if again := client.Snapshot(); again.Version != 2 || again.Data.Count != 2 || snap.Data.Count != 2 {
+		t.Fatalf("Expected an independent copy, got %+v", again.Data)
+	}
+}
+
+func TestClientUpdatesCoalesce(t *testing.T) {
+	serverData := &ServerData{Name: "coalesce"}
+	serverData.State.Throttle = velox.MinThrottle
+	server := httptest.NewServer(velox.SyncHandler(serverData))
+	defer server.Close()
+	client, err := velox.NewClient(server.URL, &ClientData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Retry = false
+	synced := make(chan struct{}, 1)
+	client.OnEvent = func(name string, payload json.RawMessage) { synced <- struct{}{} }
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// a consumer which is not reading
+	updatesCtx, stop := context.WithCancel(ctx)
+	updates := client.Updates(updatesCtx, 0)
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	for i := 1; i <= 5; i++ {
+		serverData.Lock()
+		serverData.Count = i
+		serverData.Unlock()
+		serverData.Push()
+		waitFor(t, "version", func() bool { return client.Version() == int64(i+1) })
+	}
+	// events are handled in order, so the last update has been handled
+	if err := serverData.Broadcast("sync", nil); err != nil {
+		t.Fatal(err)
+	}
+	<-synced
+	// only the latest is kept
+	select {
+	case snap := <-updates:
+		if snap.Version != 6 || snap.Data.Count != 5 {
+			t.Fatalf("Expected the latest snapshot, got %d %+v", snap.Version, snap.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for snapshot")
+	}
+	stop()
+	for range updates {
+	}
+}
+
+func TestClientSnapshotProposals(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverData := &ServerData{Name: "initial", Count: 1}
+	client, clientData := proposeServer(t, ctx, serverData)
+	updates := client.Updates(ctx, 10)
+	// hold the server's lock, so the proposal stays pending
+	serverData.Lock()
+	proposed := make(chan error, 1)
+	go func() {
+		proposed <- client.Propose(ctx, map[string]any{"name": "proposed"})
+	}()
+	waitFor(t, "optimistic update", func() bool {
+		clientData.Lock()
+		defer clientData.Unlock()
+		return clientData.Name == "proposed"
+	})
+	// the snapshot is of the server state, at its version
+	if snap := client.Snapshot(); snap.Version != 1 || snap.Data.Name != "initial" {
+		t.Fatalf("Expected the server state at version 1, got %d %+v", snap.Version, snap.Data)
+	}
+	serverData.Unlock()
+	if err := <-proposed; err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	// the next snapshot is the server's version with the proposal
+	select {
+	case snap := <-updates:
+		if snap.Version != 2 || snap.Data.Name != "proposed" {
+			t.Fatalf("Unexpected snapshot %d %+v", snap.Version, snap.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for snapshot")
+	}
+}
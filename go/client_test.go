@@ -4,11 +4,14 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	velox "github.com/jpillora/velox/go"
 	"google.golang.org/grpc/test/bufconn"
 )
@@ -505,3 +508,218 @@ func TestNewClientValidation(t *testing.T) {
 		t.Error("Expected non-nil client")
 	}
 }
+
+func TestClientTransportAuto(t *testing.T) {
+	serverData := &ServerData{Name: "auto"}
+	handler := velox.SyncHandler(serverData)
+	var blockWS atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy which does not support websockets
+		if blockWS.Load() && r.Header.Get("Upgrade") == "websocket" {
+			http.Error(w, "no upgrades", http.StatusBadRequest)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	for _, tc := range []struct {
+		blockWS bool
+		expect  velox.Transport
+	}{
+		{false, velox.TransportWebSocket},
+		{true, velox.TransportSSE},
+	} {
+		blockWS.Store(tc.blockWS)
+		clientData := &ClientData{}
+		client, err := velox.NewClient(server.URL, clientData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Transport = velox.TransportAuto
+		client.Retry = false
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		go client.Connect(ctx)
+		waitFor(t, string(tc.expect), func() bool { return client.Version() == 1 })
+		if got := client.ActiveTransport(); got != tc.expect {
+			t.Fatalf("Expected %s, got %s", tc.expect, got)
+		}
+		client.Disconnect()
+		cancel()
+		if got := client.ActiveTransport(); got != "" {
+			t.Fatalf("Expected no transport once disconnected, got %s", got)
+		}
+	}
+}
+
+func TestClientTransportAutoFallback(t *testing.T) {
+	serverData := &ServerData{Name: "auto"}
+	var conns atomic.Value
+	serverData.State.Hooks = &velox.Hooks{
+		OnConnect: func(c velox.Conn, transport velox.Transport) { conns.Store(c) },
+	}
+	handler := velox.SyncHandler(serverData)
+	var upgrades atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy which holds upgrades open
+		if r.Header.Get("Upgrade") == "websocket" {
+			upgrades.Add(1)
+			<-r.Context().Done()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	clientData := &ClientData{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportAuto
+	client.HandshakeTimeout = 100 * time.Millisecond
+	var connects atomic.Int32
+	client.OnConnect = func() { connects.Add(1) }
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t0 := time.Now()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "fallback", func() bool { return client.Version() == 1 })
+	if d := time.Since(t0); d > time.Second {
+		t.Fatalf("Expected to fall back after the handshake timeout, took %s", d)
+	}
+	// reconnects use sse, without trying websockets again
+	conns.Load().(velox.Conn).Close()
+	waitFor(t, "reconnect", func() bool { return connects.Load() == 2 })
+	if got := client.ActiveTransport(); got != velox.TransportSSE {
+		t.Fatalf("Expected sse, got %s", got)
+	}
+	if n := upgrades.Load(); n != 1 {
+		t.Fatalf("Expected 1 upgrade attempt, got %d", n)
+	}
+}
+
+func TestClientTransportAutoAck(t *testing.T) {
+	serverData := &ServerData{Name: "auto"}
+	serverData.State.Throttle = velox.MinThrottle
+	handler := velox.SyncHandler(serverData)
+	var sseAcks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "websocket" {
+			http.Error(w, "no upgrades", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Has("ack") {
+			sseAcks.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	clientData := &ClientData{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportAuto
+	client.Ack = true
+	var connects atomic.Int32
+	client.OnConnect = func() { connects.Add(1) }
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "initial state", func() bool { return client.Version() == 1 })
+	for i := 1; i <= 2; i++ {
+		serverData.Lock()
+		serverData.Count = i
+		serverData.Unlock()
+		serverData.Push()
+		waitFor(t, "update", func() bool { return client.Version() == int64(i+1) })
+	}
+	if got := client.ActiveTransport(); got != velox.TransportSSE {
+		t.Fatalf("Expected sse, got %s", got)
+	}
+	if n := connects.Load(); n != 1 {
+		t.Fatalf("Expected 1 connect, got %d", n)
+	}
+	if n := sseAcks.Load(); n != 0 {
+		t.Fatalf("Expected no acks requested over sse, got %d", n)
+	}
+}
+
+func TestClientPingInterval(t *testing.T) {
+	var pings atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.WriteJSON(&velox.Update{ID: "a", Version: 1, Body: []byte(`{"name":"ping"}`)})
+		for {
+			_, b, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(b) == "ping" {
+				pings.Add(1)
+			}
+		}
+	}))
+	defer server.Close()
+	client, err := velox.NewClient(server.URL, &ClientData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportWebSocket
+	client.PingInterval = 20 * time.Millisecond
+	client.Retry = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "pings", func() bool { return pings.Load() >= 3 })
+}
+
+func TestClientOnErrorUnlocked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		// does not unmarshal into ClientData
+		ws.WriteJSON(&velox.Update{ID: "a", Version: 1, Body: []byte(`{"count":"one"}`)})
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	clientData := &ClientData{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportWebSocket
+	client.Retry = false
+	errs := make(chan error, 1)
+	client.OnError = func(err error) {
+		// the data is not locked while reporting errors
+		clientData.Lock()
+		clientData.Unlock()
+		errs <- err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "failed to unmarshal into data") {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the error")
+	}
+}
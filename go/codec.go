@@ -0,0 +1,75 @@
+package velox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Codec marshals and unmarshals JSON. A State or Client[T] uses its Codec
+// for all of its marshalling and diffing, so that encoding/json may be
+// replaced with a faster or stricter implementation. Unmarshalling into an
+// interface{} must produce JSON value trees: nil, bool, float64 or
+// json.Number, string, []interface{} and map[string]interface{}.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec is the default Codec, using encoding/json
+	JSONCodec Codec = jsonCodec{}
+	// NumberCodec is encoding/json with numbers decoded as json.Number,
+	// so that integers beyond 2^53 are diffed and sent exactly
+	NumberCodec Codec = numberCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type numberCodec struct{}
+
+func (numberCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (numberCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid character after top-level value")
+	}
+	return nil
+}
+
+// codecOr returns c, or the default codec if nil
+func codecOr(c Codec) Codec {
+	if c == nil {
+		return JSONCodec
+	}
+	return c
+}
+
+// codec used by this State
+func (s *State) codec() Codec {
+	return codecOr(s.Codec)
+}
+
+// codecOf returns the codec of the State embedded in gostruct, if any
+func codecOf(gostruct interface{}) Codec {
+	if se, ok := gostruct.(stateEmbedded); ok {
+		return se.self().codec()
+	}
+	return JSONCodec
+}
@@ -0,0 +1,82 @@
+package velox_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+// countingCodec records its use
+type countingCodec struct {
+	velox.Codec
+	marshals, unmarshals atomic.Int32
+}
+
+func (c *countingCodec) Marshal(v any) ([]byte, error) {
+	c.marshals.Add(1)
+	return c.Codec.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v any) error {
+	c.unmarshals.Add(1)
+	return c.Codec.Unmarshal(data, v)
+}
+
+func TestNumberCodec(t *testing.T) {
+	type Data struct {
+		Big  int64  `json:"big"`
+		Name string `json:"name"`
+	}
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Data
+	}
+	serverCodec := &countingCodec{Codec: velox.NumberCodec}
+	test := &TestStruct{Data: Data{Big: 1<<53 + 1, Name: "a name which makes deltas smaller"}}
+	test.State.Throttle = 10 * time.Millisecond
+	test.State.Codec = serverCodec
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+
+	clientCodec := &countingCodec{Codec: velox.NumberCodec}
+	clientData := &struct {
+		sync.Mutex
+		Data
+	}{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.Codec = clientCodec
+	client.Retry = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "initial state", func() bool { return client.Version() == 1 })
+
+	// integers beyond 2^53 survive deltas
+	test.Lock()
+	test.Big += 2
+	test.Unlock()
+	test.Push()
+	waitFor(t, "version 2", func() bool { return client.Version() == 2 })
+	clientData.Lock()
+	big := clientData.Big
+	clientData.Unlock()
+	if big != 1<<53+3 {
+		t.Fatalf("Expected %d, got %d", int64(1<<53+3), big)
+	}
+	if serverCodec.marshals.Load() == 0 || serverCodec.unmarshals.Load() == 0 {
+		t.Fatal("Expected the server to use its codec")
+	}
+	if clientCodec.unmarshals.Load() == 0 {
+		t.Fatal("Expected the client to use its codec")
+	}
+}
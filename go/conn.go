@@ -1,49 +1,102 @@
 package velox
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 // Conn represents a single live connection being synchronised.
-// ID is current set to the connection's remote address.
 type Conn interface {
 	ID() string
 	Connected() bool
 	Wait()
 	Push()
 	Close() error
+	// Metadata returned by State.Authorize for this connection
+	Metadata() Metadata
+	// Context carries the values of the connection's request,
+	// and is cancelled when the connection closes
+	Context() context.Context
+	// RemoteAddr of the connection's request
+	RemoteAddr() string
+	// Transport used by the connection
+	Transport() Transport
+	// ConnectedAt is the time the connection was established
+	ConnectedAt() time.Time
+	// Version last sent to the connection
+	Version() int64
+	// Send a named event to the connection, outside of the synced state
+	Send(name string, payload any) error
+	// AckedVersion is the last version the client confirmed applying,
+	// always zero unless the client enabled acknowledgements
+	AckedVersion() int64
+	// Lag is the time since the oldest unacknowledged version was sent
+	Lag() time.Duration
 }
 
 type conn struct {
-	transport   transport
-	state       *State
-	connected   bool
-	connectedAt time.Time
-	connectedCh chan struct{}
-	waiter      sync.WaitGroup
-	id          int64
-	addr        string
-	first       uint32
-	pushing     uint32
-	queued      uint32
-	sendVerMut  sync.Mutex // serialises send, protects version
-	version     int64
-}
-
-func newConn(id int64, addr string, state *State, version int64) *conn {
-	return &conn{
+	transport     transport
+	state         *State
+	connected     bool
+	connectedAt   time.Time
+	connectedCh   chan struct{}
+	waiter        sync.WaitGroup
+	id            int64
+	addr          string
+	req           *http.Request
+	first         uint32
+	pushing       uint32
+	queued        uint32
+	sendVerMut    sync.Mutex // serialises send, protects version
+	version       int64
+	format        DeltaFormat
+	transportType Transport
+	view          connView // filtered connections only
+	log           *slog.Logger
+	writer        *writeGate
+	timeouts      atomic.Int32 // consecutive send timeouts
+	acks          bool         // client acknowledges versions
+	release       func()       // releases the connection's admission
+	metadata      Metadata
+	gzip          bool         // updates are compressed
+	bytesSent     atomic.Int64 // update bodies sent
+	ctx           context.Context
+	cancel        context.CancelFunc
+	ackMut        sync.Mutex
+	ack           connAcks
+	sent          struct {
+		mut     sync.Mutex
+		version int64         // copy of version, readable during a send
+		ch      chan struct{} // closed when version changes
+		err     error         // last send error
+	}
+}
+
+func newConn(id int64, r *http.Request, state *State, version int64) *conn {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	c := &conn{
+		ctx:         ctx,
+		cancel:      cancel,
 		connectedCh: make(chan struct{}),
 		id:          id,
-		addr:        addr,
+		addr:        r.RemoteAddr,
+		req:         r,
 		state:       state,
 		version:     version,
+		format:      DeltaFormat(r.URL.Query().Get("format")),
+		log:         state.logger().With("conn", id, "addr", r.RemoteAddr),
 	}
+	c.sent.version = version
+	c.writer = newWriteGate(c.Push)
+	return c
 }
 
 // ID of this connection
@@ -56,6 +109,31 @@ func (c *conn) Connected() bool {
 	return c.connected
 }
 
+// Metadata returned by State.Authorize
+func (c *conn) Metadata() Metadata {
+	return c.metadata
+}
+
+// Context of this connection
+func (c *conn) Context() context.Context {
+	return c.ctx
+}
+
+// RemoteAddr of this connection
+func (c *conn) RemoteAddr() string {
+	return c.addr
+}
+
+// Transport used by this connection
+func (c *conn) Transport() Transport {
+	return c.transportType
+}
+
+// ConnectedAt is when this connection was established
+func (c *conn) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
 // Read the current version
 func (c *conn) Version() int64 {
 	c.sendVerMut.Lock()
@@ -78,9 +156,26 @@ func (c *conn) Close() error {
 func (c *conn) connect(w http.ResponseWriter, r *http.Request) error {
 	//choose transport
 	if r.Header.Get("Accept") == "text/event-stream" {
-		c.transport = &eventSourceTransport{writeTimeout: c.state.WriteTimeout}
+		c.transportType = TransportSSE
+		c.transport = &eventSourceTransport{
+			writeTimeout: c.state.SlowConsumer.writeDeadline(c.state.WriteTimeout),
+			codec:        c.state.codec(),
+		}
 	} else if r.Header.Get("Upgrade") == "websocket" {
-		c.transport = &websocketsTransport{writeTimeout: c.state.WriteTimeout}
+		c.transportType = TransportWebSocket
+		ws := &websocketsTransport{
+			writeTimeout: c.state.SlowConsumer.writeDeadline(c.state.WriteTimeout),
+			recv:         c.receive,
+			encodings:    c.state.Encodings,
+			codec:        c.state.codec(),
+		}
+		//filtered connections are sent their own bodies
+		if c.state.Filter == nil {
+			ws.cache = &c.state.wire
+		}
+		c.transport = ws
+		c.acks = r.URL.Query().Get("ack") == "1"
+		c.gzip = strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
 	} else {
 		return fmt.Errorf("invalid sync request")
 	}
@@ -88,6 +183,9 @@ func (c *conn) connect(w http.ResponseWriter, r *http.Request) error {
 	if err := c.transport.connect(w, r); err != nil {
 		return err
 	}
+	if c.transportType == TransportSSE {
+		c.gzip = w.Header().Get("Content-Encoding") == "gzip"
+	}
 	//initial ping
 	if err := c.send(&Update{Ping: true}); err != nil {
 		return fmt.Errorf("failed to send initial event")
@@ -101,7 +199,7 @@ func (c *conn) connect(w http.ResponseWriter, r *http.Request) error {
 		for {
 			select {
 			case <-time.After(c.state.PingInterval):
-				if err := c.send(&Update{Ping: true}); err != nil {
+				if err := c.send(&Update{Ping: true}); err != nil && c.dropSlow(err) {
 					goto disconnected
 				}
 			case <-c.connectedCh:
@@ -110,6 +208,7 @@ func (c *conn) connect(w http.ResponseWriter, r *http.Request) error {
 		}
 	disconnected:
 		c.connected = false
+		c.stopAcks()
 		c.Close()
 		//unblock waiters
 		c.waiter.Done()
@@ -127,73 +226,210 @@ func (c *conn) connect(w http.ResponseWriter, r *http.Request) error {
 // Push will the current state only to this client.
 // Blocks until push is complete.
 func (c *conn) Push() {
-	if c.state.Debug {
-		log.Printf("velox: conn[%d] Push() called, connVersion=%d", c.id, c.Version())
-	}
-	//attempt to mark state as 'pushing'
-	if !atomic.CompareAndSwapUint32(&c.pushing, 0, 1) {
-		//if already pushing, mark queued
-		if c.state.Debug {
-			log.Printf("velox: conn[%d] Push() already pushing, marking queued", c.id)
-		}
-		atomic.StoreUint32(&c.queued, 1)
-		return
+	if c.schedule() {
+		c.drain()
+	}
+}
+
+// schedule marks a push as due, returning true when the caller must
+// run it. A connection which is already pushing skips to the latest
+// version when its current push completes.
+func (c *conn) schedule() bool {
+	atomic.StoreUint32(&c.queued, 1)
+	if atomic.CompareAndSwapUint32(&c.pushing, 0, 1) {
+		return true
 	}
-	defer func() {
-		//no longer pushing
+	if debugging(c.log) {
+		c.log.Debug("velox: conn push queued")
+	}
+	return false
+}
+
+// drain runs queued pushes, then releases pushing
+func (c *conn) drain() {
+	for {
+		for atomic.CompareAndSwapUint32(&c.queued, 1, 0) {
+			c.push()
+		}
 		atomic.StoreUint32(&c.pushing, 0)
-		//queued? dequeue and push again
-		if atomic.CompareAndSwapUint32(&c.queued, 1, 0) {
-			c.Push() //within same goroutine
+		//catch pushes queued before pushing was released
+		if atomic.LoadUint32(&c.queued) == 0 || !atomic.CompareAndSwapUint32(&c.pushing, 0, 1) {
+			return
 		}
-	}()
+	}
+}
+
+// push the current state, requires pushing
+func (c *conn) push() {
+	debug := debugging(c.log)
+	if debug {
+		c.log.Debug("velox: conn push", "version", c.sentVersion())
+	}
 	//current state data
 	d := &c.state.data
 	d.mut.RLock()
-	if c.Version() == d.version {
+	if c.sentVersion() == d.version {
 		d.mut.RUnlock()
-		if c.state.Debug {
-			log.Printf("velox: conn[%d] already at version %d, skipping", c.id, d.version)
+		if debug {
+			c.log.Debug("velox: conn up to date", "version", d.version)
 		}
 		return
 	}
-	update := &Update{Version: d.version}
+	//filtered connections diff their own view
+	if c.state.Filter != nil {
+		id, data, version, changed := d.id, d.bytes, d.version, d.changed
+		d.mut.RUnlock()
+		c.pushFiltered(id, data, version, changed)
+		return
+	}
+	update := &Update{Version: d.version, stateID: d.id}
 	//first push? include id
 	if atomic.CompareAndSwapUint32(&c.first, 0, 1) {
 		update.ID = d.id
 	}
 	//choose optimal update (send the smallest)
-	if d.delta != nil &&
-		c.Version() == (d.version-1) &&
+	deltaSince := c.state.deltaSince
+	if c.format == DeltaJSONPatch {
+		deltaSince = c.state.jsonPatchSince
+	}
+	if delta, ok := deltaSince(c.sentVersion()); ok &&
 		len(d.bytes) > 0 &&
-		len(d.delta) < len(d.bytes) {
+		len(delta) < len(d.bytes) {
 		update.Delta = true
-		update.Body = d.delta
+		update.Body = delta
+		if c.format == DeltaJSONPatch {
+			update.Format = DeltaJSONPatch
+		}
 	} else {
 		update.Delta = false
 		update.Body = d.bytes
 	}
+	changed := d.changed
 	d.mut.RUnlock()
 	//unlock data and send!
-	if c.state.Debug {
-		log.Printf("velox: conn[%d] sending version=%d delta=%v bodyLen=%d", c.id, update.Version, update.Delta, len(update.Body))
+	if debug {
+		c.log.Debug("velox: conn send", "version", update.Version, "delta", update.Delta, "bytes", len(update.Body))
 	}
-	if err := c.send(update); err != nil {
-		log.Printf("velox: send failed: %s", err)
+	if err := c.sendUpdate(update, changed); err != nil && c.dropSlow(err) {
+		c.log.Warn("velox: send failed", "version", update.Version, "err", err)
 		c.Close()
 		return
 	}
 }
 
-// send to connection, ensure only 1 concurrent sender
+// send a message to the connection, waiting for the write in progress.
+// Unlike state updates, replies, events and pings are never skipped.
 func (c *conn) send(upd *Update) error {
+	return c.sendAcquired(upd, c.writer.wait(c.connectedCh, len(upd.Body)))
+}
+
+// sendState sends a state update, waiting up to WriteTimeout for the
+// write in progress, or skipping it, see SlowConsumerPolicy. Skipped
+// updates are pushed again once the write completes.
+func (c *conn) sendState(upd *Update) error {
+	p := c.state.SlowConsumer
+	return c.sendAcquired(upd, c.writer.acquire(c.state.WriteTimeout, p.SkipToLatest, len(upd.Body)))
+}
+
+// sendAcquired writes the update once the writer was acquired without
+// error, ensuring only 1 concurrent sender
+func (c *conn) sendAcquired(upd *Update, err error) error {
+	if err == nil {
+		err = c.write(upd)
+		c.writer.done(err)
+	}
+	//skipped sends are not failures
+	if err != nil && !errors.Is(err, errWriteBusy) {
+		if errors.Is(err, ErrSendTimeout) {
+			c.timeouts.Add(1)
+		}
+		c.sent.mut.Lock()
+		c.sent.err = err
+		c.sent.mut.Unlock()
+	}
+	return err
+}
+
+// write to the connection, requires the writer
+func (c *conn) write(upd *Update) error {
 	c.sendVerMut.Lock()
 	defer c.sendVerMut.Unlock()
-	//send (transports responsiblity to enforce timeouts)
+	//send (transports responsiblity to enforce write deadlines)
 	if err := c.transport.send(upd); err != nil {
 		return err
 	}
-	// mark new current version
-	c.version = upd.Version
+	c.timeouts.Store(0)
+	c.bytesSent.Add(int64(len(upd.Body)))
+	// mark new current version (pings have none)
+	if upd.Version > 0 {
+		c.version = upd.Version
+		c.versionSent(upd.Version)
+		if c.acks {
+			c.awaitAck(upd.Version)
+		}
+	}
 	return nil
 }
+
+// resync forces the next push to send the id and full state
+func (c *conn) resync() {
+	c.setVersion(0)
+	atomic.StoreUint32(&c.first, 0)
+}
+
+// setVersion marks the connection as up to date without sending
+func (c *conn) setVersion(version int64) {
+	c.sendVerMut.Lock()
+	c.version = version
+	c.versionSent(version)
+	c.sendVerMut.Unlock()
+}
+
+// sentVersion is the last version sent, without
+// waiting for a send in progress
+func (c *conn) sentVersion() int64 {
+	c.sent.mut.Lock()
+	defer c.sent.mut.Unlock()
+	return c.sent.version
+}
+
+// versionSent wakes anyone waiting on the connection's version
+func (c *conn) versionSent(version int64) {
+	c.sent.mut.Lock()
+	c.sent.version = version
+	if c.sent.ch != nil {
+		close(c.sent.ch)
+		c.sent.ch = nil
+	}
+	c.sent.mut.Unlock()
+}
+
+// waitVersion blocks until the given version has been sent, returning
+// an error if the connection closes or the context expires first
+func (c *conn) waitVersion(ctx context.Context, version int64) error {
+	for {
+		c.sent.mut.Lock()
+		if c.sent.version >= version {
+			c.sent.mut.Unlock()
+			return nil
+		}
+		if c.sent.ch == nil {
+			c.sent.ch = make(chan struct{})
+		}
+		ch := c.sent.ch
+		c.sent.mut.Unlock()
+		select {
+		case <-ch:
+		case <-c.connectedCh:
+			c.sent.mut.Lock()
+			err := c.sent.err
+			c.sent.mut.Unlock()
+			if err == nil {
+				err = ErrConnClosed
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
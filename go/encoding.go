@@ -0,0 +1,160 @@
+package velox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Encoding is a binary wire format for WebSocket connections, negotiated
+// with the subprotocol "velox.<name>". Connections without a negotiated
+// Encoding use JSON. Encodings convert JSON value trees, as decoded by
+// encoding/json with UseNumber: nil, bool, json.Number, string,
+// []interface{} and map[string]interface{}.
+type Encoding interface {
+	// Name identifies the encoding, for example "msgpack"
+	Name() string
+	// Marshal encodes a JSON value tree
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes into a JSON value tree
+	Unmarshal(b []byte) (interface{}, error)
+}
+
+var (
+	// MsgPack is the MessagePack Encoding
+	MsgPack Encoding = msgpackEncoding{}
+	// CBOR is the CBOR (RFC 8949) Encoding
+	CBOR Encoding = cborEncoding{}
+	// DefaultEncodings is the default State.Encodings value.
+	DefaultEncodings = []Encoding{MsgPack, CBOR}
+)
+
+// maxEncodingDepth limits the nesting of decoded values
+const maxEncodingDepth = 1000
+
+func subprotocol(e Encoding) string {
+	return "velox." + e.Name()
+}
+
+func subprotocols(encodings []Encoding) []string {
+	names := make([]string, len(encodings))
+	for i, e := range encodings {
+		names[i] = subprotocol(e)
+	}
+	return names
+}
+
+// negotiatedEncoding returns the encoding for the given subprotocol,
+// or nil for JSON
+func negotiatedEncoding(encodings []Encoding, protocol string) Encoding {
+	for _, e := range encodings {
+		if protocol == subprotocol(e) {
+			return e
+		}
+	}
+	return nil
+}
+
+// decodeJSON decodes JSON into a value tree, keeping numbers exact
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// encodeWire encodes v, with the given JSON body in place of
+// its "body" field
+func encodeWire(codec Codec, e Encoding, v interface{}, body json.RawMessage) ([]byte, error) {
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := decodeJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 {
+		obj, ok := tree.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot add body to %T", tree)
+		}
+		if obj["body"], err = decodeJSON(body); err != nil {
+			return nil, err
+		}
+	}
+	return e.Marshal(tree)
+}
+
+// encodeUpdate encodes an update for the wire, as JSON when e is nil
+func encodeUpdate(codec Codec, e Encoding, upd *Update) ([]byte, error) {
+	if e == nil {
+		return codec.Marshal(upd)
+	}
+	env := *upd
+	env.Body = nil
+	return encodeWire(codec, e, &env, upd.Body)
+}
+
+// wireToJSON converts an encoded message to JSON
+func wireToJSON(codec Codec, e Encoding, b []byte) ([]byte, error) {
+	tree, err := e.Unmarshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Marshal(tree)
+}
+
+// wireCache keeps the encoded messages of recent state updates.
+// Each version is sent to every connection with the same body,
+// so it is encoded once per encoding and shared.
+type wireCache struct {
+	mut     sync.Mutex
+	entries [8]wireEntry
+	next    int
+}
+
+// wireKey is the envelope of a state update
+type wireKey struct {
+	encoding Encoding // nil for JSON
+	id       string
+	delta    bool
+	version  int64
+	format   DeltaFormat
+}
+
+type wireEntry struct {
+	key  wireKey
+	body []byte
+	msg  []byte
+}
+
+// encode the update with e, or return its cached message
+func (c *wireCache) encode(codec Codec, e Encoding, upd *Update) ([]byte, error) {
+	//only state updates are shared
+	if c == nil || len(upd.Body) == 0 || upd.Version == 0 || upd.Ping || upd.Reply != 0 ||
+		upd.Error != "" || upd.Event != "" || upd.Payload != nil || upd.Goodbye || upd.Retry != 0 {
+		return encodeUpdate(codec, e, upd)
+	}
+	key := wireKey{encoding: e, id: upd.ID, delta: upd.Delta, version: upd.Version, format: upd.Format}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	for _, entry := range c.entries {
+		//bodies are never modified, the same slice is the same body,
+		//and the entry keeps it from being reused
+		if entry.key == key && len(entry.body) == len(upd.Body) && &entry.body[0] == &upd.Body[0] {
+			return entry.msg, nil
+		}
+	}
+	msg, err := encodeUpdate(codec, e, upd)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[c.next] = wireEntry{key: key, body: upd.Body, msg: msg}
+	c.next = (c.next + 1) % len(c.entries)
+	return msg, nil
+}
@@ -0,0 +1,261 @@
+package velox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// cborEncoding implements CBOR (RFC 8949) for JSON value trees
+type cborEncoding struct{}
+
+func (cborEncoding) Name() string {
+	return "cbor"
+}
+
+func (cborEncoding) Marshal(v interface{}) ([]byte, error) {
+	return cborAppend(nil, v)
+}
+
+func (cborEncoding) Unmarshal(b []byte) (interface{}, error) {
+	d := &cborDecoder{msgpackDecoder{b: b}}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.i != len(b) {
+		return nil, errors.New("cbor: trailing data")
+	}
+	return v, nil
+}
+
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborTag    = 6 << 5
+	cborSimple = 7 << 5
+)
+
+func cborAppend(b []byte, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if t {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return cborAppendInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return cborAppendHead(b, cborUint, u), nil
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil, err
+		}
+		return cborAppendFloat(b, f), nil
+	case float64:
+		return cborAppendFloat(b, t), nil
+	case int:
+		return cborAppendInt(b, int64(t)), nil
+	case int64:
+		return cborAppendInt(b, t), nil
+	case uint64:
+		return cborAppendHead(b, cborUint, t), nil
+	case string:
+		return append(cborAppendHead(b, cborText, uint64(len(t))), t...), nil
+	case []byte:
+		return append(cborAppendHead(b, cborBytes, uint64(len(t))), t...), nil
+	case []interface{}:
+		b = cborAppendHead(b, cborArray, uint64(len(t)))
+		var err error
+		for _, e := range t {
+			if b, err = cborAppend(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = cborAppendHead(b, cborMap, uint64(len(t)))
+		var err error
+		for _, k := range sortedKeys(t) {
+			b = append(cborAppendHead(b, cborText, uint64(len(k))), k...)
+			if b, err = cborAppend(b, t[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported type %T", v)
+}
+
+func cborAppendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+func cborAppendInt(b []byte, i int64) []byte {
+	if i < 0 {
+		return cborAppendHead(b, cborNegInt, uint64(-(i + 1)))
+	}
+	return cborAppendHead(b, cborUint, uint64(i))
+}
+
+func cborAppendFloat(b []byte, f float64) []byte {
+	//whole numbers are smaller as integers
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 && !(f == 0 && math.Signbit(f)) {
+		return cborAppendInt(b, int64(f))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f))
+}
+
+type cborDecoder struct {
+	msgpackDecoder // shares the byte reader
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxEncodingDepth {
+		return nil, errors.New("cbor: too deeply nested")
+	}
+	c, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := c[0]&0xe0, c[0]&0x1f
+	if major == cborSimple {
+		return d.simple(info)
+	}
+	if info == 31 {
+		return nil, errors.New("cbor: indefinite lengths are not supported")
+	}
+	n := uint64(info)
+	if info >= 24 {
+		if info > 27 {
+			return nil, fmt.Errorf("cbor: invalid additional info %d", info)
+		}
+		if n, err = d.uint(1 << (info - 24)); err != nil {
+			return nil, err
+		}
+	}
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return -1 - float64(n), nil
+		}
+		return -1 - int64(n), nil
+	case cborBytes:
+		b, err := d.read(cborLen(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case cborText:
+		return d.str(cborLen(n))
+	case cborArray:
+		if n > uint64(len(d.b)-d.i) {
+			return nil, errShortBuffer
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case cborMap:
+		if n > uint64(len(d.b)-d.i)/2 {
+			return nil, errShortBuffer
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			if m[key], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	//tags have no json equivalent, use the tagged value
+	return d.value(depth + 1)
+}
+
+// cborLen returns n if it may be a length, otherwise
+// -1 which fails to read
+func cborLen(n uint64) int {
+	if n > math.MaxInt32 {
+		return -1
+	}
+	return int(n)
+}
+
+func (d *cborDecoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null, undefined
+		return nil, nil
+	case 25:
+		n, err := d.uint(2)
+		return float16(uint16(n)), err
+	case 26:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 27:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	}
+	return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+}
+
+// float16 converts an IEEE 754 half precision float
+func float16(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
@@ -0,0 +1,298 @@
+package velox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// msgpackEncoding implements MessagePack for JSON value trees
+type msgpackEncoding struct{}
+
+func (msgpackEncoding) Name() string {
+	return "msgpack"
+}
+
+func (msgpackEncoding) Marshal(v interface{}) ([]byte, error) {
+	return msgpackAppend(nil, v)
+}
+
+func (msgpackEncoding) Unmarshal(b []byte) (interface{}, error) {
+	d := &msgpackDecoder{b: b}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.i != len(b) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return v, nil
+}
+
+func msgpackAppend(b []byte, v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if t {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(t), 10, 64); err == nil {
+			return msgpackAppendInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return msgpackAppendUint(b, u), nil
+		}
+		f, err := strconv.ParseFloat(string(t), 64)
+		if err != nil {
+			return nil, err
+		}
+		return msgpackAppendFloat(b, f), nil
+	case float64:
+		return msgpackAppendFloat(b, t), nil
+	case int:
+		return msgpackAppendInt(b, int64(t)), nil
+	case int64:
+		return msgpackAppendInt(b, t), nil
+	case uint64:
+		return msgpackAppendUint(b, t), nil
+	case string:
+		b = msgpackAppendHead(b, len(t), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		return append(b, t...), nil
+	case []byte:
+		b = msgpackAppendHead(b, len(t), 0, 0, 0xc4, 0xc5, 0xc6)
+		return append(b, t...), nil
+	case []interface{}:
+		b = msgpackAppendHead(b, len(t), 0x90, 16, 0, 0xdc, 0xdd)
+		var err error
+		for _, e := range t {
+			if b, err = msgpackAppend(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = msgpackAppendHead(b, len(t), 0x80, 16, 0, 0xde, 0xdf)
+		var err error
+		for _, k := range sortedKeys(t) {
+			b, _ = msgpackAppend(b, k)
+			if b, err = msgpackAppend(b, t[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+// msgpackAppendHead appends a length prefix, using the fix
+// type when n < fixMax, else the smallest of the 8/16/32 bit
+// types. A zero code is unavailable.
+func msgpackAppendHead(b []byte, n int, fix byte, fixMax int, c8, c16, c32 byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(b, fix|byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		return append(b, c8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, c16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, c32), uint32(n))
+}
+
+// msgpackAppendInt appends the smallest format, unsigned
+// when positive, as reference encoders do
+func msgpackAppendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return msgpackAppendUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+func msgpackAppendUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 127:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(u))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), u)
+}
+
+func msgpackAppendFloat(b []byte, f float64) []byte {
+	//whole numbers are smaller as integers
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 && !(f == 0 && math.Signbit(f)) {
+		return msgpackAppendInt(b, int64(f))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+}
+
+type msgpackDecoder struct {
+	b []byte
+	i int
+}
+
+var errShortBuffer = errors.New("unexpected end of data")
+
+func (d *msgpackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.b)-d.i < n {
+		return nil, errShortBuffer
+	}
+	b := d.b[d.i : d.i+n]
+	d.i += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxEncodingDepth {
+		return nil, errors.New("msgpack: too deeply nested")
+	}
+	c, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	code := c[0]
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code&0xf0 == 0x80:
+		return d.mapOf(int(code&0x0f), depth)
+	case code&0xf0 == 0x90:
+		return d.arrayOf(int(code&0x0f), depth)
+	case code&0xe0 == 0xa0:
+		return d.str(int(code & 0x1f))
+	}
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (code - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.read(int(n))
+		if err != nil {
+			return nil, err
+		}
+		//json has no binary type, keep as a base64 string
+		return append([]byte{}, b...), nil
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (code - 0xcc))
+		if n > math.MaxInt64 {
+			return n, err
+		}
+		return int64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (code - 0xd0)
+		n, err := d.uint(size)
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (code - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (code - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (code - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", code)
+}
+
+func (d *msgpackDecoder) str(n int) (string, error) {
+	b, err := d.read(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) arrayOf(n int, depth int) ([]interface{}, error) {
+	//each element is at least one byte
+	if n > len(d.b)-d.i {
+		return nil, errShortBuffer
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) mapOf(n int, depth int) (map[string]interface{}, error) {
+	if 2*n > len(d.b)-d.i {
+		return nil, errShortBuffer
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		if m[key], err = d.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
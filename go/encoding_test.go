@@ -0,0 +1,417 @@
+package velox_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	docs := []string{
+		`null`,
+		`true`,
+		`{"a":1,"b":-1,"c":-33,"d":300,"e":-70000,"f":5000000000,"g":1.5,"h":-0.25}`,
+		`{"s":"` + strings.Repeat("x", 40) + `","t":"` + strings.Repeat("y", 300) + `","u":""}`,
+		`{"l":[1,[2,[3]],{"o":null}],"m":{"n":{"o":{}}},"e":[]}`,
+		`{"big":9007199254740993,"neg":-9007199254740993,"exp":1e300}`,
+	}
+	for _, e := range []velox.Encoding{velox.MsgPack, velox.CBOR} {
+		for _, doc := range docs {
+			var tree any
+			dec := json.NewDecoder(strings.NewReader(doc))
+			dec.UseNumber()
+			dec.Decode(&tree)
+			b, err := e.Marshal(tree)
+			if err != nil {
+				t.Fatalf("%s: marshal %s: %v", e.Name(), doc, err)
+			}
+			v, err := e.Unmarshal(b)
+			if err != nil {
+				t.Fatalf("%s: unmarshal %s: %v", e.Name(), doc, err)
+			}
+			got, _ := json.Marshal(v)
+			assertJSONEqual(t, doc, string(got))
+			if strings.Contains(doc, "9007199254740993") && !strings.Contains(string(got), "9007199254740993") {
+				t.Fatalf("%s: lost integer precision: %s", e.Name(), got)
+			}
+			// truncated input must fail, not panic
+			for i := 0; i < len(b); i++ {
+				if _, err := e.Unmarshal(b[:i]); err == nil {
+					t.Fatalf("%s: expected error for truncated %x", e.Name(), b[:i])
+				}
+			}
+		}
+	}
+}
+
+func TestEncodingVectors(t *testing.T) {
+	for _, tc := range []struct {
+		enc  velox.Encoding
+		doc  string
+		want string
+	}{
+		{velox.MsgPack, `{"a":1,"b":[true,null]}`, "82a16101a16292c3c0"},
+		{velox.MsgPack, `-1.5`, "cbbff8000000000000"},
+		{velox.CBOR, `{"a":1,"b":[true,null]}`, "a2616101616282f5f6"},
+		{velox.CBOR, `-500`, "3901f3"},
+	} {
+		var tree any
+		dec := json.NewDecoder(strings.NewReader(tc.doc))
+		dec.UseNumber()
+		dec.Decode(&tree)
+		b, err := tc.enc.Marshal(tree)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", tc.enc.Name(), err)
+		}
+		if got := hex.EncodeToString(b); got != tc.want {
+			t.Fatalf("%s: %s: expected %s, got %s", tc.enc.Name(), tc.doc, tc.want, got)
+		}
+	}
+	// decodes types the encoder does not produce
+	for _, tc := range []struct {
+		enc  velox.Encoding
+		hex  string
+		want string
+	}{
+		{velox.CBOR, "f93e00", `1.5`},              // float16
+		{velox.CBOR, "c11a514b67b0", `1363896240`}, // tagged epoch
+		{velox.MsgPack, "ca3fc00000", `1.5`},       // float32
+		{velox.MsgPack, "cdffff", `65535`},         // uint16
+	} {
+		b, _ := hex.DecodeString(tc.hex)
+		v, err := tc.enc.Unmarshal(b)
+		if err != nil {
+			t.Fatalf("%s: unmarshal %s: %v", tc.enc.Name(), tc.hex, err)
+		}
+		if got, _ := json.Marshal(v); string(got) != tc.want {
+			t.Fatalf("%s: %s: expected %s, got %s", tc.enc.Name(), tc.hex, tc.want, got)
+		}
+	}
+}
+
+func TestEncodingSync(t *testing.T) {
+	type Telemetry struct {
+		velox.State
+		sync.Mutex
+		Name    string    `json:"name"`
+		Count   int       `json:"count"`
+		Samples []float64 `json:"samples"`
+	}
+	serverData := &Telemetry{Name: "sensor", Samples: []float64{0.5, 1.25}}
+	serverData.State.Throttle = 10 * time.Millisecond
+	server := httptest.NewServer(velox.SyncHandler(serverData))
+	defer server.Close()
+	serverData.State.HandleAction("echo", func(c velox.Conn, name string, payload json.RawMessage) (any, error) {
+		return payload, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the server sends binary frames once negotiated
+	dialer := websocket.Dialer{Subprotocols: []string{"velox.msgpack"}}
+	ws, _, err := dialer.DialContext(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer ws.Close()
+	if p := ws.Subprotocol(); p != "velox.msgpack" {
+		t.Fatalf("Expected velox.msgpack, got %q", p)
+	}
+	if mt, _, err := ws.ReadMessage(); err != nil || mt != websocket.BinaryMessage {
+		t.Fatalf("Expected binary message, got %d (%v)", mt, err)
+	}
+
+	for _, enc := range []velox.Encoding{velox.MsgPack, velox.CBOR} {
+		clientData := &struct {
+			sync.Mutex
+			Name    string    `json:"name"`
+			Count   int       `json:"count"`
+			Samples []float64 `json:"samples"`
+		}{}
+		client, err := velox.NewClient(server.URL, clientData)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		client.Transport = velox.TransportWebSocket
+		client.Encoding = enc
+		client.Retry = false
+		go client.Connect(ctx)
+		waitFor(t, enc.Name()+" initial state", func() bool { return client.Version() > 0 })
+		var echo map[string]any
+		if err := client.Call(ctx, "echo", map[string]any{"x": 1.5}, &echo); err != nil || echo["x"] != 1.5 {
+			t.Fatalf("%s: unexpected echo %v (%v)", enc.Name(), echo, err)
+		}
+		serverData.Lock()
+		serverData.Count++
+		serverData.Samples = append(serverData.Samples, -3.75)
+		count, samples := serverData.Count, len(serverData.Samples)
+		serverData.Unlock()
+		serverData.Push()
+		waitFor(t, enc.Name()+" update", func() bool {
+			clientData.Lock()
+			defer clientData.Unlock()
+			return clientData.Count == count && len(clientData.Samples) == samples
+		})
+		clientData.Lock()
+		if clientData.Name != "sensor" || clientData.Samples[samples-1] != -3.75 {
+			t.Fatalf("%s: unexpected data %+v", enc.Name(), clientData)
+		}
+		clientData.Unlock()
+		client.Disconnect()
+	}
+}
+
+// interopVectors are reference encodings: RFC 8949 Appendix A for CBOR,
+// and the output of the MessagePack reference implementations, which use
+// the smallest format. Those marked encode are produced exactly, the rest
+// are decoded only.
+var interopVectors = map[velox.Encoding][]struct {
+	hex    string
+	json   string
+	encode bool
+}{
+	velox.CBOR: {
+		{"00", `0`, true},
+		{"01", `1`, true},
+		{"0a", `10`, true},
+		{"17", `23`, true},
+		{"1818", `24`, true},
+		{"1819", `25`, true},
+		{"1864", `100`, true},
+		{"1903e8", `1000`, true},
+		{"1a000f4240", `1000000`, true},
+		{"1b000000e8d4a51000", `1000000000000`, true},
+		{"1bffffffffffffffff", `18446744073709551615`, true},
+		{"3bffffffffffffffff", `-18446744073709552000`, false}, // beyond int64, as a float
+		{"20", `-1`, true},
+		{"29", `-10`, true},
+		{"3863", `-100`, true},
+		{"3903e7", `-1000`, true},
+		{"f90000", `0`, false},
+		{"f98000", `-0`, false},
+		{"f93c00", `1`, false},
+		{"fb3ff199999999999a", `1.1`, true},
+		{"f93e00", `1.5`, false},
+		{"f97bff", `65504`, false},
+		{"fa47c35000", `100000`, false},
+		{"fa7f7fffff", `3.4028234663852886e+38`, false},
+		{"fb7e37e43c8800759c", `1e+300`, true},
+		{"f90001", `5.960464477539063e-8`, false},
+		{"f90400", `0.00006103515625`, false},
+		{"f9c400", `-4`, false},
+		{"fbc010666666666666", `-4.1`, true},
+		{"f4", `false`, true},
+		{"f5", `true`, true},
+		{"f6", `null`, true},
+		{"f7", `null`, false}, // undefined
+		{"c074323031332d30332d32315432303a30343a30305a", `"2013-03-21T20:04:00Z"`, false},
+		{"c11a514b67b0", `1363896240`, false},
+		{"c1fb41d452d9ec200000", `1363896240.5`, false},
+		{"d74401020304", `"AQIDBA=="`, false}, // bytes, as base64
+		{"d818456449455446", `"ZElFVEY="`, false},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", `"http://www.example.com"`, false},
+		{"40", `""`, false},
+		{"4401020304", `"AQIDBA=="`, false},
+		{"60", `""`, true},
+		{"6161", `"a"`, true},
+		{"6449455446", `"IETF"`, true},
+		{"62225c", `"\"\\"`, true},
+		{"62c3bc", `"ü"`, true},
+		{"63e6b0b4", `"水"`, true},
+		{"64f0908591", `"𐅑"`, true},
+		{"80", `[]`, true},
+		{"83010203", `[1,2,3]`, true},
+		{"8301820203820405", `[1,[2,3],[4,5]]`, true},
+		{"98190102030405060708090a0b0c0d0e0f101112131415161718181819", `[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25]`, true},
+		{"a0", `{}`, true},
+		{"a201020304", `{"1":2,"3":4}`, false}, // keys as strings
+		{"a26161016162820203", `{"a":1,"b":[2,3]}`, true},
+		{"826161a161626163", `["a",{"b":"c"}]`, true},
+		{"a56161614161626142616361436164614461656145", `{"a":"A","b":"B","c":"C","d":"D","e":"E"}`, true},
+	},
+	velox.MsgPack: {
+		{"c0", `null`, true},
+		{"c2", `false`, true},
+		{"c3", `true`, true},
+		{"00", `0`, true},
+		{"7f", `127`, true},
+		{"cc80", `128`, true},
+		{"ccff", `255`, true},
+		{"cd0100", `256`, true},
+		{"cdffff", `65535`, true},
+		{"ce00010000", `65536`, true},
+		{"ceffffffff", `4294967295`, true},
+		{"cf0000000100000000", `4294967296`, true},
+		{"cfffffffffffffffff", `18446744073709551615`, true},
+		{"ff", `-1`, true},
+		{"e0", `-32`, true},
+		{"d0df", `-33`, true},
+		{"d080", `-128`, true},
+		{"d1ff7f", `-129`, true},
+		{"d18000", `-32768`, true},
+		{"d2ffff7fff", `-32769`, true},
+		{"d280000000", `-2147483648`, true},
+		{"d3ffffffff7fffffff", `-2147483649`, true},
+		{"d38000000000000000", `-9223372036854775808`, true},
+		{"cb3ff199999999999a", `1.1`, true},
+		{"cb7e37e43c8800759c", `1e+300`, true},
+		{"cb8000000000000000", `-0`, false},
+		{"ca3fc00000", `1.5`, false},
+		{"cc05", `5`, false},
+		{"d000", `0`, false},
+		{"a0", `""`, true},
+		{"a161", `"a"`, true},
+		{"a2c3bc", `"ü"`, true},
+		{"bf" + strings.Repeat("78", 31), `"` + strings.Repeat("x", 31) + `"`, true},
+		{"d920" + strings.Repeat("78", 32), `"` + strings.Repeat("x", 32) + `"`, true},
+		{"da0100" + strings.Repeat("78", 256), `"` + strings.Repeat("x", 256) + `"`, true},
+		{"d903616263", `"abc"`, false},
+		{"c403010203", `"AQID"`, false}, // bin, as base64
+		{"90", `[]`, true},
+		{"93010203", `[1,2,3]`, true},
+		{"9f" + strings.Repeat("00", 15), `[` + strings.Repeat("0,", 14) + `0]`, true},
+		{"dc0010" + strings.Repeat("00", 16), `[` + strings.Repeat("0,", 15) + `0]`, true},
+		{"dc000101", `[1]`, false},
+		{"80", `{}`, true},
+		{"81a16191a0", `{"a":[""]}`, true},
+		{"82a16101a16292c3c0", `{"a":1,"b":[true,null]}`, true},
+		{"de0001a16101", `{"a":1}`, false},
+		{"8101a162", `{"1":"b"}`, false}, // keys as strings
+	},
+}
+
+func TestEncodingInterop(t *testing.T) {
+	for enc, vectors := range interopVectors {
+		for _, tc := range vectors {
+			b, _ := hex.DecodeString(tc.hex)
+			v, err := enc.Unmarshal(b)
+			if err != nil {
+				t.Fatalf("%s: unmarshal %s: %v", enc.Name(), tc.hex, err)
+			}
+			if got, err := json.Marshal(v); err != nil || string(got) != tc.json {
+				t.Fatalf("%s: %s: expected %s, got %s (%v)", enc.Name(), tc.hex, tc.json, got, err)
+			}
+			if !tc.encode {
+				continue
+			}
+			b, err = enc.Marshal(jsonTree(t, tc.json))
+			if err != nil {
+				t.Fatalf("%s: marshal %s: %v", enc.Name(), tc.json, err)
+			}
+			if got := hex.EncodeToString(b); got != tc.hex {
+				t.Fatalf("%s: %s: expected %s, got %s", enc.Name(), tc.json, tc.hex, got)
+			}
+		}
+	}
+	// unsupported types fail
+	for _, tc := range []struct {
+		enc velox.Encoding
+		hex string
+	}{
+		{velox.MsgPack, "c1"},     // never used
+		{velox.MsgPack, "d40100"}, // fixext
+		{velox.CBOR, "5f"},        // indefinite length
+		{velox.CBOR, "1c"},        // reserved additional info
+		{velox.CBOR, "f0"},        // unassigned simple value
+	} {
+		b, _ := hex.DecodeString(tc.hex)
+		if v, err := tc.enc.Unmarshal(b); err == nil {
+			t.Fatalf("%s: expected error for %s, got %v", tc.enc.Name(), tc.hex, v)
+		}
+	}
+}
+
+func FuzzMsgPack(f *testing.F) {
+	fuzzEncoding(f, velox.MsgPack)
+}
+
+func FuzzCBOR(f *testing.F) {
+	fuzzEncoding(f, velox.CBOR)
+}
+
+// fuzzEncoding checks that decoding never panics, and that whatever
+// decodes can be encoded again, stably
+func fuzzEncoding(f *testing.F, enc velox.Encoding) {
+	for _, tc := range interopVectors[enc] {
+		b, _ := hex.DecodeString(tc.hex)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		v, err := enc.Unmarshal(b)
+		if err != nil {
+			return
+		}
+		b2, err := enc.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal %x: %v", b, err)
+		}
+		v2, err := enc.Unmarshal(b2)
+		if err != nil {
+			t.Fatalf("unmarshal %x: %v", b2, err)
+		}
+		b3, err := enc.Marshal(v2)
+		if err != nil || !bytes.Equal(b2, b3) {
+			t.Fatalf("unstable encoding of %x: %x != %x (%v)", b, b2, b3, err)
+		}
+	})
+}
+
+func TestEncodingShared(t *testing.T) {
+	var cache velox.WireCache
+	body := json.RawMessage(`{"name":"shared"}`)
+	encode := func(e velox.Encoding, upd *velox.Update) []byte {
+		t.Helper()
+		b, err := cache.Encode(e, upd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// each version is encoded once and shared
+	for _, enc := range []velox.Encoding{nil, velox.MsgPack, velox.CBOR} {
+		a := encode(enc, &velox.Update{Version: 2, Body: body})
+		b := encode(enc, &velox.Update{Version: 2, Body: body})
+		if &a[0] != &b[0] {
+			t.Fatalf("%v: expected a shared message", enc)
+		}
+		// which differs by envelope and by body
+		if c := encode(enc, &velox.Update{Version: 2, ID: "first", Body: body}); &c[0] == &a[0] {
+			t.Fatalf("%v: expected the id to be encoded", enc)
+		}
+		if c := encode(enc, &velox.Update{Version: 2, Body: append(json.RawMessage(nil), body...)}); &c[0] == &a[0] {
+			t.Fatalf("%v: expected another body to be encoded", enc)
+		}
+	}
+	json1 := encode(nil, &velox.Update{Version: 2, Body: body})
+	mp := encode(velox.MsgPack, &velox.Update{Version: 2, Body: body})
+	if bytes.Equal(json1, mp) {
+		t.Fatal("Expected encodings to be cached separately")
+	}
+	// replies and events are never shared
+	a := encode(velox.MsgPack, &velox.Update{Event: "e", Payload: body})
+	b := encode(velox.MsgPack, &velox.Update{Event: "e", Payload: body})
+	if &a[0] == &b[0] {
+		t.Fatal("Expected events to be encoded each time")
+	}
+}
+
+// jsonTree decodes a JSON value tree, keeping numbers exact
+func jsonTree(t *testing.T, doc string) any {
+	t.Helper()
+	var tree any
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		t.Fatalf("decode %s: %v", doc, err)
+	}
+	return tree
+}
@@ -0,0 +1,70 @@
+package velox
+
+import (
+	"errors"
+	"sync"
+)
+
+// Broadcast sends a named event to every connection. Events are not part
+// of the synced state, they have no version, and clients which are not
+// connected never receive them. Broadcast returns once the event has been
+// sent to each connection, reporting any send errors.
+func (s *State) Broadcast(name string, payload any) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	upd, err := s.event(name, payload)
+	if err != nil {
+		return err
+	}
+	s.connMut.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connMut.Unlock()
+	errs := make([]error, len(conns))
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Go(func() {
+			errs[i] = c.sendEvent(upd)
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Send a named event to this connection, see State.Broadcast.
+func (c *conn) Send(name string, payload any) error {
+	upd, err := c.state.event(name, payload)
+	if err != nil {
+		return err
+	}
+	return c.sendEvent(upd)
+}
+
+// event marshals a named event
+func (s *State) event(name string, payload any) (*Update, error) {
+	if name == "" {
+		return nil, errors.New("velox: event name required")
+	}
+	upd := &Update{Event: name}
+	if payload != nil {
+		b, err := s.codec().Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		upd.Payload = b
+	}
+	return upd, nil
+}
+
+// sendEvent sends the event, closing the connection if it cannot keep up
+func (c *conn) sendEvent(upd *Update) error {
+	err := c.send(upd)
+	if err != nil && c.dropSlow(err) {
+		c.log.Warn("velox: send failed", "event", upd.Event, "err", err)
+		c.Close()
+	}
+	return err
+}
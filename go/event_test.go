@@ -0,0 +1,89 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestEvents(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	conns := make(chan velox.Conn, 3)
+	test.State.Hooks = &velox.Hooks{OnConnect: func(c velox.Conn, transport velox.Transport) { conns <- c }}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type event struct {
+		name    string
+		payload string
+	}
+	connect := func(transport velox.Transport, enc velox.Encoding) (velox.Conn, chan event) {
+		client, err := velox.NewClient(server.URL, &struct {
+			sync.Mutex
+			Value string `json:"value"`
+		}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Transport = transport
+		client.Encoding = enc
+		client.Retry = false
+		events := make(chan event, 10)
+		client.OnEvent = func(name string, payload json.RawMessage) {
+			events <- event{name, string(payload)}
+		}
+		go client.Connect(ctx)
+		t.Cleanup(client.Disconnect)
+		waitFor(t, string(transport)+" initial state", func() bool { return client.Version() == 1 })
+		return <-conns, events
+	}
+	sseConn, sseEvents := connect(velox.TransportSSE, nil)
+	_, wsEvents := connect(velox.TransportWebSocket, velox.MsgPack)
+	expect := func(events chan event, name, payload string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.name != name {
+				t.Fatalf("Expected event %s, got %s", name, e.name)
+			}
+			assertJSONEqual(t, payload, e.payload)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %s", name)
+		}
+	}
+	if err := test.Broadcast("toast", map[string]string{"text": "hello"}); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+	expect(sseEvents, "toast", `{"text":"hello"}`)
+	expect(wsEvents, "toast", `{"text":"hello"}`)
+	if err := sseConn.Send("job", map[string]any{"done": true}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	expect(sseEvents, "job", `{"done":true}`)
+	select {
+	case e := <-wsEvents:
+		t.Fatalf("Unexpected event %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// events are not versions
+	if v := test.Version(); v != 1 {
+		t.Fatalf("Expected version 1, got %d", v)
+	}
+	if v := sseConn.Version(); v != 1 {
+		t.Fatalf("Expected conn version 1, got %d", v)
+	}
+	if err := test.Broadcast("", nil); err == nil {
+		t.Fatal("Expected error for an unnamed event")
+	}
+}
@@ -1,4 +1,62 @@
 package velox
 
+import "encoding/json"
+
 // BindAll is exported for testing only.
 var BindAll = bindAll
+
+// ComposePatches is exported for testing only.
+var ComposePatches = composePatches
+
+// JSONPatchBytes is exported for testing only.
+func JSONPatchBytes(a, b []byte) ([]byte, error) {
+	return jsonPatchBytes(JSONCodec, a, b, nil)
+}
+
+// KeyedJSONPatchBytes is exported for testing only.
+func KeyedJSONPatchBytes(a, b []byte, key KeyFunc) ([]byte, error) {
+	return jsonPatchBytes(JSONCodec, a, b, key)
+}
+
+// ApplyJSONPatch is exported for testing only.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+	ops, err := decodePatchOps(JSONCodec, patch)
+	if err != nil {
+		return nil, err
+	}
+	v, err = applyJSONPatch(v, ops)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// MatchPath is exported for testing only.
+func MatchPath(pattern, path string) bool {
+	return matchPath(splitPath(pattern), splitPath(path))
+}
+
+// WireCache is exported for testing only.
+type WireCache = wireCache
+
+// Encode is exported for testing only.
+func (c *WireCache) Encode(e Encoding, upd *Update) ([]byte, error) {
+	return c.encode(JSONCodec, e, upd)
+}
+
+// DeltaHistory is exported for testing only.
+type DeltaHistory = deltaHistory
+
+// Add is exported for testing only.
+func (h *DeltaHistory) Add(version int64, delta, prev []byte, maxCount int) {
+	h.add(version, delta, prev, JSONCodec, maxCount, 0)
+}
+
+// Since is exported for testing only.
+func (h *DeltaHistory) Since(version int64) ([]byte, bool) {
+	return h.since(version, JSONCodec)
+}
@@ -0,0 +1,97 @@
+package velox
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// FilterFunc projects the marshalled state into the view of a single
+// connection, for example to redact fields a user may not see. It is
+// given the connection, the request which opened it and the current
+// state, and must return a JSON object. The data must not be modified.
+type FilterFunc func(c Conn, r *http.Request, data json.RawMessage) (json.RawMessage, error)
+
+// connView is the last filtered state sent to a connection,
+// deltas for filtered connections are calculated against it.
+type connView struct {
+	bytes   []byte
+	patcher mergePatcher
+}
+
+// pushFiltered sends this connection its own projection of the given
+// state, as either a delta against its previous view or in full. When the
+// projection has not changed, nothing is sent and the version is recorded.
+// The view is only kept once it was sent, so a failed send is retried
+// against the view the client has.
+func (c *conn) pushFiltered(id string, data []byte, version int64, changed time.Time) {
+	update := &Update{Version: version, stateID: id}
+	view := c.view
+	if atomic.LoadUint32(&c.first) == 0 {
+		// first push or resync, send the full view
+		view = connView{}
+	}
+	if len(data) == 0 {
+		// state was cleared, clear the view too
+		view = connView{}
+	} else {
+		b, err := c.state.Filter(c, c.req, data)
+		if err != nil {
+			c.log.Error("velox: filter failed", "version", version, "err", err)
+			return
+		}
+		codec := c.state.codec()
+		view.patcher.codec = codec
+		prev := view.patcher.prev
+		delta, err := view.patcher.patch(b)
+		if err != nil {
+			c.log.Error("velox: filter diff failed", "version", version, "err", err)
+			return
+		}
+		if c.format == DeltaJSONPatch && view.bytes != nil && !bytes.Equal(delta, []byte(`{}`)) {
+			ops := jsonPatchDiff([]patchOp{}, "", prev, view.patcher.prev, c.state.ArrayKey)
+			if delta, err = encodePatchOps(codec, ops); err != nil {
+				c.log.Error("velox: filter diff failed", "version", version, "err", err)
+				return
+			}
+			update.Format = DeltaJSONPatch
+		}
+		if view.bytes != nil && bytes.Equal(delta, []byte(`{}`)) {
+			// this connection cannot see the change
+			c.view = view
+			c.setVersion(version)
+			return
+		}
+		if view.bytes != nil && len(delta) < len(b) {
+			update.Delta = true
+			update.Body = delta
+		} else {
+			update.Format = ""
+			update.Body = b
+		}
+		view.bytes = b
+	}
+	//first push? include id
+	first := atomic.CompareAndSwapUint32(&c.first, 0, 1)
+	if first {
+		update.ID = id
+	}
+	if debugging(c.log) {
+		c.log.Debug("velox: conn send filtered", "version", update.Version, "delta", update.Delta, "bytes", len(update.Body))
+	}
+	err := c.sendUpdate(update, changed)
+	if err == nil {
+		c.view = view
+		return
+	}
+	if first {
+		//not sent, the retry includes the id and full view
+		atomic.StoreUint32(&c.first, 0)
+	}
+	if c.dropSlow(err) {
+		c.log.Warn("velox: send failed", "version", update.Version, "err", err)
+		c.Close()
+	}
+}
@@ -0,0 +1,160 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestFilteredSync(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Public string `json:"public"`
+		Secret string `json:"secret"`
+		Notes  string `json:"notes"`
+	}
+	test := &TestStruct{Public: "a", Secret: "x", Notes: "unchanged notes"}
+	test.State.Throttle = 10 * time.Millisecond
+	// only admins may see the secret
+	test.State.Filter = func(c velox.Conn, r *http.Request, data json.RawMessage) (json.RawMessage, error) {
+		if r.URL.Query().Get("role") == "admin" {
+			return data, nil
+		}
+		m := map[string]any{}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		delete(m, "secret")
+		return json.Marshal(m)
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	user := &testClient{id: 1, url: server.URL + "?role=user"}
+	admin := &testClient{id: 2, url: server.URL + "?role=admin"}
+	for _, c := range []*testClient{user, admin} {
+		if err := c.connect(ctx); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer c.disconnect()
+		if u, _, err := c.next(); err != nil || !u.Ping {
+			t.Fatalf("Expected ping, got %+v (%v)", u, err)
+		}
+	}
+	body := func(c *testClient) (*velox.Update, map[string]any) {
+		u, _, err := c.next()
+		if err != nil {
+			t.Fatalf("client %d: failed to get update: %v", c.id, err)
+		}
+		m := map[string]any{}
+		if err := json.Unmarshal(u.Body, &m); err != nil {
+			t.Fatalf("client %d: failed to unmarshal body: %v", c.id, err)
+		}
+		return u, m
+	}
+	// initial state
+	if u, m := body(user); u.Delta || m["public"] != "a" || m["secret"] != nil {
+		t.Fatalf("user: unexpected initial state: %+v", m)
+	}
+	if u, m := body(admin); u.Delta || m["public"] != "a" || m["secret"] != "x" {
+		t.Fatalf("admin: unexpected initial state: %+v", m)
+	}
+	// secret only change is hidden from user, then a public change
+	// arrives as a delta against the user's own view
+	test.Lock()
+	test.Secret = "y"
+	test.Unlock()
+	test.Push()
+	if u, m := body(admin); !u.Delta || m["secret"] != "y" || u.Version != 2 {
+		t.Fatalf("admin: expected secret delta at version 2, got %+v %+v", u, m)
+	}
+	time.Sleep(50 * time.Millisecond)
+	test.Lock()
+	test.Public = "b"
+	test.Unlock()
+	test.Push()
+	u, m := body(user)
+	if !u.Delta || len(m) != 1 || m["public"] != "b" {
+		t.Fatalf("user: expected public-only delta, got %+v %+v", u, m)
+	}
+	if u.Version != 3 {
+		t.Fatalf("user: expected version 3, got %d", u.Version)
+	}
+	if u, m := body(admin); !u.Delta || m["public"] != "b" {
+		t.Fatalf("admin: expected public delta, got %+v %+v", u, m)
+	}
+}
+
+func TestFilteredSkippedPush(t *testing.T) {
+	test := &slowState{}
+	test.State.Throttle = velox.MinThrottle
+	test.State.WriteTimeout = 50 * time.Millisecond
+	test.State.SlowConsumer = velox.SlowConsumerPolicy{SkipToLatest: true, MaxTimeouts: 1000}
+	test.State.Filter = func(c velox.Conn, r *http.Request, data json.RawMessage) (json.RawMessage, error) {
+		return data, nil
+	}
+	var skipped atomic.Int64
+	test.State.Hooks = &velox.Hooks{
+		OnSend: func(info velox.SendInfo) {
+			if info.Skipped {
+				skipped.Add(1)
+			}
+		},
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ws := stalledClient(t, server.URL)
+	defer ws.Close()
+	waitFor(t, "connection", func() bool { return test.NumConnections() == 1 })
+	// stall the connection with events, which wait for each other
+	for i := 0; ; i++ {
+		if i == 50 {
+			t.Fatal("Timed out stalling")
+		}
+		sent := make(chan error, 1)
+		go func() {
+			sent <- test.Broadcast("blob", blob(1<<20))
+		}()
+		select {
+		case <-sent:
+			continue
+		case <-time.After(4 * test.State.WriteTimeout):
+		}
+		break
+	}
+	// the push is skipped while the event is written
+	var change string
+	var changes int
+	waitFor(t, "skipped push", func() bool {
+		changes++
+		test.Lock()
+		change = fmt.Sprintf("change %d", changes)
+		test.Blob = change
+		test.Unlock()
+		test.Push()
+		return skipped.Load() > 0
+	})
+	// once the client reads again, the change is retried
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		u := &velox.Update{}
+		if err := ws.ReadJSON(u); err != nil {
+			t.Fatalf("Failed to read the change: %v", err)
+		}
+		if u.Event == "" && strings.Contains(string(u.Body), strconv.Quote(change)) {
+			break
+		}
+	}
+}
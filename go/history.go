@@ -0,0 +1,127 @@
+package velox
+
+import "sync"
+
+// deltaHistory is a bounded ring of recent merge patches, which
+// lets clients that are a few versions behind resume with a single
+// combined delta instead of the full state.
+type deltaHistory struct {
+	entries []historyEntry         // oldest first, versions are contiguous
+	size    int                    // total bytes of all deltas
+	base    map[string]interface{} // the state the oldest delta applies to
+	// combined deltas to the latest version, by the version they
+	// apply to, calculated on demand and cleared by add and reset
+	combined struct {
+		mut    sync.Mutex
+		deltas map[int64][]byte
+	}
+}
+
+// historyEntry moves a client from version-1 to version
+type historyEntry struct {
+	version int64
+	delta   []byte
+}
+
+// add records the delta which produced version from prev, then trims
+// the oldest entries until the history is within the given limits.
+// A maxCount of zero disables the history, a maxBytes of zero
+// means no byte limit.
+func (h *deltaHistory) add(version int64, delta, prev []byte, codec Codec, maxCount, maxBytes int) {
+	if maxCount <= 0 {
+		h.reset()
+		return
+	}
+	h.combined.deltas = nil
+	//versions must be contiguous, otherwise start again
+	if n := len(h.entries); n > 0 && h.entries[n-1].version != version-1 {
+		h.reset()
+	}
+	if len(h.entries) == 0 {
+		h.base = map[string]interface{}{}
+		if err := codec.Unmarshal(prev, &h.base); err != nil || h.base == nil {
+			h.reset()
+			return
+		}
+	}
+	h.entries = append(h.entries, historyEntry{version: version, delta: delta})
+	h.size += len(delta)
+	drop := 0
+	for len(h.entries)-drop > maxCount || (maxBytes > 0 && h.size > maxBytes && len(h.entries)-drop > 0) {
+		//the base moves on to the next delta
+		var patch map[string]interface{}
+		if err := codec.Unmarshal(h.entries[drop].delta, &patch); err != nil {
+			h.reset()
+			return
+		}
+		mergeObjects(h.base, patch)
+		h.size -= len(h.entries[drop].delta)
+		drop++
+	}
+	if drop > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[drop:]...)
+	}
+}
+
+// reset clears the history
+func (h *deltaHistory) reset() {
+	h.entries = nil
+	h.size = 0
+	h.base = nil
+	h.combined.deltas = nil
+}
+
+// since returns a single merge patch which moves a client at the
+// given version to the latest version. Returns false when the version
+// is outside of the history, or a delta cannot be decoded.
+func (h *deltaHistory) since(version int64, codec Codec) ([]byte, bool) {
+	if len(h.entries) == 0 {
+		return nil, false
+	}
+	first := h.entries[0].version
+	if version < first-1 || version >= h.entries[len(h.entries)-1].version {
+		return nil, false
+	}
+	if last := h.entries[len(h.entries)-1]; version == last.version-1 {
+		return last.delta, true
+	}
+	//calculated once per version, on demand, so a reconnecting
+	//storm of clients at the same version shares the work
+	h.combined.mut.Lock()
+	defer h.combined.mut.Unlock()
+	if b, ok := h.combined.deltas[version]; ok {
+		return b, b != nil
+	}
+	b, ok := h.combine(version, codec)
+	if h.combined.deltas == nil {
+		h.combined.deltas = map[int64][]byte{}
+	}
+	h.combined.deltas[version] = b
+	return b, ok
+}
+
+// combine the deltas after the given version into a single merge patch
+func (h *deltaHistory) combine(version int64, codec Codec) ([]byte, bool) {
+	//the state at version, which the combined delta applies to
+	orig := copyValue(h.base).(map[string]interface{})
+	var combined map[string]interface{}
+	for _, e := range h.entries {
+		var patch map[string]interface{}
+		if err := codec.Unmarshal(e.delta, &patch); err != nil {
+			return nil, false
+		}
+		switch {
+		case e.version <= version:
+			mergeObjects(orig, patch)
+		case combined == nil:
+			combined = patch
+		default:
+			composePatches(combined, patch, orig)
+		}
+	}
+	b, err := codec.Marshal(combined)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
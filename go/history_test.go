@@ -0,0 +1,223 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestComposePatches(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		orig, a, b string
+		want       string
+	}{
+		{"disjoint", `{}`, `{"a":1}`, `{"b":2}`, `{"a":1,"b":2}`},
+		{"overwrite", `{"a":0}`, `{"a":1}`, `{"a":2}`, `{"a":2}`},
+		{"delete after set", `{}`, `{"a":1}`, `{"a":null}`, `{"a":null}`},
+		{"nested merge", `{"o":{"y":2}}`, `{"o":{"x":1}}`, `{"o":{"y":null}}`, `{"o":{"x":1,"y":null}}`},
+		{"array replace", `{"l":[]}`, `{"l":[1]}`, `{"l":[1,2]}`, `{"l":[1,2]}`},
+		{"merge into replaced", `{"o":2}`, `{"o":1}`, `{"o":{"x":1,"y":null}}`, `{"o":{"x":1}}`},
+		{"merge into deleted", `{"o":[1]}`, `{"o":null}`, `{"o":{"x":{"y":1,"z":null}}}`, `{"o":{"x":{"y":1}}}`},
+		{"merge into replaced object", `{"o":{"x":0,"z":{"q":1}}}`, `{"o":1}`, `{"o":{"x":1}}`, `{"o":{"x":1,"z":null}}`},
+		{"merge into deleted object", `{"u":{"bob":{"name":"Bob","status":"away"}}}`, `{"u":{"bob":null}}`, `{"u":{"bob":{"name":"Bob"}}}`, `{"u":{"bob":{"status":null}}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var orig, a, b, want map[string]any
+			json.Unmarshal([]byte(tc.orig), &orig)
+			json.Unmarshal([]byte(tc.a), &a)
+			json.Unmarshal([]byte(tc.b), &b)
+			json.Unmarshal([]byte(tc.want), &want)
+			sequential := mergePatch(mergePatch(orig, a), b)
+			velox.ComposePatches(a, b, orig)
+			if !reflect.DeepEqual(a, want) {
+				t.Fatalf("expected %v, got %v", want, a)
+			}
+			if combined := mergePatch(orig, a); !reflect.DeepEqual(combined, sequential) {
+				t.Fatalf("expected the combined patch to produce %v, got %v", sequential, combined)
+			}
+		})
+	}
+}
+
+// mergePatch applies an RFC 7386 merge patch, without modifying target
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	out := map[string]any{}
+	if t, ok := target.(map[string]any); ok {
+		for k, v := range t {
+			out[k] = v
+		}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(out, k)
+		} else {
+			out[k] = mergePatch(out[k], v)
+		}
+	}
+	return out
+}
+
+func TestDeltaHistoryCombinedOnce(t *testing.T) {
+	h := &velox.DeltaHistory{}
+	h.Add(2, []byte(`{"a":1}`), []byte(`{}`), 10)
+	h.Add(3, []byte(`{"b":2}`), nil, 10)
+	h.Add(4, []byte(`{"c":3}`), nil, 10)
+	a, ok := h.Since(2)
+	if !ok || string(a) != `{"b":2,"c":3}` {
+		t.Fatalf("Unexpected combined delta: %s (%v)", a, ok)
+	}
+	// reconnecting clients at the same version share the combined delta
+	if b, _ := h.Since(2); &a[0] != &b[0] {
+		t.Fatal("Expected the combined delta to be reused")
+	}
+	// until the next version
+	h.Add(5, []byte(`{"d":4}`), nil, 10)
+	if b, _ := h.Since(2); string(b) != `{"b":2,"c":3,"d":4}` {
+		t.Fatalf("Unexpected combined delta: %s", b)
+	}
+}
+
+func TestDeltaHistoryResume(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		A, B, C int
+		Padding string
+	}
+	test := &TestStruct{Padding: "some data which is not changing between versions"}
+	test.State.Throttle = 10 * time.Millisecond
+	test.State.DeltaHistory = 2
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	// bump to version 4, one field per version
+	for i, set := range []func(){
+		func() { test.A = 1 },
+		func() { test.B = 2 },
+		func() { test.C = 3 },
+	} {
+		test.Lock()
+		set()
+		test.Unlock()
+		test.Push()
+		deadline := time.Now().Add(2 * time.Second)
+		for test.State.Version() != int64(i+2) && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	if v := test.State.Version(); v != 4 {
+		t.Fatalf("expected version 4, got %d", v)
+	}
+	resume := func(v string) *velox.Update {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c := &testClient{url: server.URL + "?id=" + test.State.ID() + "&v=" + v}
+		if err := c.connect(ctx); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer c.disconnect()
+		if u, _, err := c.next(); err != nil || !u.Ping {
+			t.Fatalf("Expected ping, got %+v (%v)", u, err)
+		}
+		u, _, err := c.next()
+		if err != nil {
+			t.Fatalf("Failed to get update: %v", err)
+		}
+		return u
+	}
+	// within the history: one combined delta
+	u := resume("2")
+	if !u.Delta || u.Version != 4 {
+		t.Fatalf("expected delta at version 4, got %+v", u)
+	}
+	if string(u.Body) != `{"B":2,"C":3}` {
+		t.Fatalf("unexpected combined delta: %s", u.Body)
+	}
+	// outside the history: full state
+	if u := resume("1"); u.Delta {
+		t.Fatalf("expected full state, got delta: %s", u.Body)
+	}
+}
+
+func TestDeltaHistoryReplaceThenPatch(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Users   map[string]map[string]string `json:"users"`
+		Other   any                          `json:"other"`
+		Padding string                       `json:"padding"`
+	}
+	test := &TestStruct{
+		Users: map[string]map[string]string{
+			"bob": {"name": "Bob", "status": "away"},
+			"amy": {"name": "Amy"},
+		},
+		Other:   map[string]any{"a": 1},
+		Padding: strings.Repeat("-", 500),
+	}
+	test.State.Throttle = 10 * time.Millisecond
+	test.State.DeltaHistory = 3
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	states := map[int64]any{}
+	record := func() {
+		t.Helper()
+		b, _ := test.State.Data()
+		var state any
+		json.Unmarshal(b, &state)
+		states[test.State.Version()] = state
+	}
+	record()
+	// objects replaced or deleted, then patched
+	for _, change := range []func(){
+		func() { delete(test.Users, "bob"); test.Other = 5 },
+		func() { test.Users["bob"] = map[string]string{"name": "Bob"}; test.Other = map[string]any{"b": 2} },
+		func() { test.Users["amy"]["status"] = "online" },
+	} {
+		test.Lock()
+		change()
+		test.Unlock()
+		v := test.State.Version()
+		test.Push()
+		waitFor(t, "push", func() bool { return test.State.Version() == v+1 })
+		record()
+	}
+	latest := test.State.Version()
+	for v := latest - 3; v < latest; v++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		c := &testClient{url: server.URL + "?id=" + test.State.ID() + "&v=" + strconv.FormatInt(v, 10)}
+		if err := c.connect(ctx); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		var u *velox.Update
+		for u == nil || u.Ping {
+			var err error
+			if u, _, err = c.next(); err != nil {
+				t.Fatalf("Failed to get update: %v", err)
+			}
+		}
+		c.disconnect()
+		cancel()
+		// one combined delta from each version in the history
+		if !u.Delta || u.Version != latest {
+			t.Fatalf("from %d: expected delta at version %d, got %+v", v, latest, u)
+		}
+		var patch any
+		json.Unmarshal(u.Body, &patch)
+		if got := mergePatch(states[v], patch); !reflect.DeepEqual(got, states[latest]) {
+			t.Fatalf("from %d: delta %s produced %v, expected %v", v, u.Body, got, states[latest])
+		}
+	}
+}
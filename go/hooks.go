@@ -0,0 +1,162 @@
+package velox
+
+import (
+	"errors"
+	"expvar"
+	"time"
+)
+
+// Hooks observe a State, for example to export metrics. Each
+// hook is optional, and is called synchronously so must not block.
+type Hooks struct {
+	// OnPush is called after each push has marshalled and diffed the data
+	OnPush func(info PushInfo)
+	// OnSend is called after each update is sent to a connection, or fails
+	OnSend func(info SendInfo)
+	// OnConnect is called when a connection is established
+	OnConnect func(c Conn, transport Transport)
+	// OnDisconnect is called when a connection closes
+	OnDisconnect func(c Conn, transport Transport, connected time.Duration)
+	// OnDrop is called when a slow connection is dropped, see SlowConsumerPolicy
+	OnDrop func(c Conn, transport Transport, err error)
+}
+
+// PushInfo describes a single push
+type PushInfo struct {
+	Version     int64         // version after the push
+	Changed     bool          // false if the data was unchanged
+	Bytes       int           // size of the data
+	DeltaBytes  int           // size of the delta from the previous version
+	Duration    time.Duration // time to marshal and diff
+	Connections int           // connections at the time of the push
+}
+
+// SendInfo describes an update sent to a single connection
+type SendInfo struct {
+	Conn      Conn
+	Transport Transport
+	Version   int64
+	Delta     bool
+	Bytes     int           // size of the update body
+	Duration  time.Duration // time to write the update
+	Latency   time.Duration // time since the version was created
+	Skipped   bool          // not sent, an earlier write is still in progress
+	Err       error         // non-nil if the send failed
+}
+
+// Timeout is true when the send failed because it took longer than WriteTimeout
+func (s SendInfo) Timeout() bool {
+	return errors.Is(s.Err, ErrSendTimeout)
+}
+
+func (s *State) hookPush(info PushInfo) {
+	if s.Hooks != nil && s.Hooks.OnPush != nil {
+		s.Hooks.OnPush(info)
+	}
+}
+
+func (s *State) hookConnect(c *conn) {
+	if s.Hooks != nil && s.Hooks.OnConnect != nil {
+		s.Hooks.OnConnect(c, c.transportType)
+	}
+}
+
+func (s *State) hookDrop(c *conn, err error) {
+	if s.Hooks != nil && s.Hooks.OnDrop != nil {
+		s.Hooks.OnDrop(c, c.transportType, err)
+	}
+}
+
+func (s *State) hookDisconnect(c *conn) {
+	if s.Hooks != nil && s.Hooks.OnDisconnect != nil {
+		s.Hooks.OnDisconnect(c, c.transportType, time.Since(c.connectedAt))
+	}
+}
+
+// sendUpdate sends a state update, reporting it to the OnSend hook
+func (c *conn) sendUpdate(upd *Update, changedAt time.Time) error {
+	hooks := c.state.Hooks
+	if hooks == nil || hooks.OnSend == nil {
+		return c.sendState(upd)
+	}
+	t0 := time.Now()
+	err := c.sendState(upd)
+	skipped := errors.Is(err, errWriteBusy)
+	hooks.OnSend(SendInfo{
+		Conn:      c,
+		Transport: c.transportType,
+		Version:   upd.Version,
+		Delta:     upd.Delta,
+		Bytes:     len(upd.Body),
+		Duration:  time.Since(t0),
+		Latency:   time.Since(changedAt),
+		Skipped:   skipped,
+		Err:       err,
+	})
+	return err
+}
+
+// NewExpvarHooks returns Hooks which count pushes, sends and connections
+// in the expvar.Map with the given name, published at /debug/vars.
+// Calling it again with the same name shares the same map.
+func NewExpvarHooks(name string) *Hooks {
+	m, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		m = expvar.NewMap(name)
+	}
+	return &Hooks{
+		OnPush: func(info PushInfo) {
+			m.Add("pushes", 1)
+			m.Add("push_duration_ns", int64(info.Duration))
+			if info.Changed {
+				m.Add("versions", 1)
+				m.Add("delta_bytes", int64(info.DeltaBytes))
+			}
+			setInt(m, "version", info.Version)
+			setInt(m, "bytes", int64(info.Bytes))
+		},
+		OnSend: func(info SendInfo) {
+			if info.Skipped {
+				m.Add("sends_skipped", 1)
+				return
+			}
+			if info.Err != nil {
+				m.Add("send_errors", 1)
+				if info.Timeout() {
+					m.Add("send_timeouts", 1)
+				}
+				return
+			}
+			m.Add("sends", 1)
+			if info.Delta {
+				m.Add("sends_delta", 1)
+			} else {
+				m.Add("sends_full", 1)
+			}
+			m.Add("sent_bytes", int64(info.Bytes))
+			m.Add("send_duration_ns", int64(info.Duration))
+			m.Add("send_latency_ns", int64(info.Latency))
+		},
+		OnConnect: func(c Conn, transport Transport) {
+			m.Add("connects", 1)
+			m.Add("connects_"+string(transport), 1)
+			m.Add("connections", 1)
+		},
+		OnDrop: func(c Conn, transport Transport, err error) {
+			m.Add("drops", 1)
+		},
+		OnDisconnect: func(c Conn, transport Transport, connected time.Duration) {
+			m.Add("disconnects", 1)
+			m.Add("connections", -1)
+		},
+	}
+}
+
+func setInt(m *expvar.Map, key string, v int64) {
+	i, ok := m.Get(key).(*expvar.Int)
+	if !ok {
+		i = new(expvar.Int)
+		m.Set(key, i)
+	}
+	i.Set(v)
+}
@@ -0,0 +1,132 @@
+package velox_test
+
+import (
+	"expvar"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestHooks(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string
+	}
+	var (
+		mut         sync.Mutex
+		pushes      []velox.PushInfo
+		sends       []velox.SendInfo
+		connects    []velox.Transport
+		disconnects int
+	)
+	test := &TestStruct{Value: "a"}
+	test.State.Hooks = &velox.Hooks{
+		OnPush: func(info velox.PushInfo) {
+			mut.Lock()
+			pushes = append(pushes, info)
+			mut.Unlock()
+		},
+		OnSend: func(info velox.SendInfo) {
+			mut.Lock()
+			sends = append(sends, info)
+			mut.Unlock()
+		},
+		OnConnect: func(c velox.Conn, transport velox.Transport) {
+			mut.Lock()
+			connects = append(connects, transport)
+			mut.Unlock()
+		},
+		OnDisconnect: func(c velox.Conn, transport velox.Transport, connected time.Duration) {
+			mut.Lock()
+			disconnects++
+			mut.Unlock()
+		},
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	client := &testClient{id: 1, url: server.URL}
+	if err := client.connect(t.Context()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if _, _, err := client.next(); err != nil { // ping
+		t.Fatal(err)
+	}
+	if _, _, err := client.next(); err != nil { // initial state
+		t.Fatal(err)
+	}
+	test.Lock()
+	test.Value = "b"
+	test.Unlock()
+	test.Push()
+	if u, _, err := client.next(); err != nil || u.Version != 2 {
+		t.Fatalf("Expected version 2, got %+v: %v", u, err)
+	}
+	client.disconnect()
+	waitFor(t, "send and disconnect hooks", func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return disconnects == 1 && len(sends) == 2
+	})
+	mut.Lock()
+	defer mut.Unlock()
+	if len(connects) != 1 || connects[0] != velox.TransportSSE {
+		t.Fatalf("Expected one sse connect, got %v", connects)
+	}
+	if len(pushes) == 0 {
+		t.Fatal("Expected push hooks")
+	}
+	if last := pushes[len(pushes)-1]; last.Version != 2 || !last.Changed || last.Bytes == 0 || last.DeltaBytes == 0 {
+		t.Fatalf("Unexpected push info %+v", last)
+	}
+	if len(sends) != 2 {
+		t.Fatalf("Expected 2 sends, got %d", len(sends))
+	}
+	if s := sends[0]; s.Version != 1 || s.Delta || s.Err != nil || s.Transport != velox.TransportSSE {
+		t.Fatalf("Unexpected first send %+v", s)
+	}
+	if s := sends[1]; s.Version != 2 || s.Bytes == 0 || s.Latency < s.Duration || s.Conn == nil {
+		t.Fatalf("Unexpected second send %+v", s)
+	}
+}
+
+func TestExpvarHooks(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		Value string
+	}
+	test := &TestStruct{Value: "a"}
+	// expvars cannot be removed, use a new map each run
+	name := fmt.Sprintf("velox_test_%d", time.Now().UnixNano())
+	test.State.Hooks = velox.NewExpvarHooks(name)
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	client := &testClient{id: 1, url: server.URL}
+	if err := client.connect(t.Context()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.disconnect()
+	client.next() // ping
+	client.next() // initial state
+	m := expvar.Get(name).(*expvar.Map)
+	// hooks are called after the send completes
+	waitFor(t, "send hook", func() bool { return m.Get("sends") != nil })
+	for key, want := range map[string]string{
+		"connects":     "1",
+		"connects_sse": "1",
+		"connections":  "1",
+		"sends":        "1",
+		"sends_full":   "1",
+	} {
+		if got := m.Get(key); got == nil || got.String() != want {
+			t.Fatalf("Expected %s=%s, got %v", key, want, got)
+		}
+	}
+	if velox.NewExpvarHooks(name) == nil {
+		t.Fatal("Expected hooks for an existing map")
+	}
+}
@@ -0,0 +1,486 @@
+package velox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DeltaFormat names the format of delta updates
+type DeltaFormat string
+
+const (
+	DeltaMergePatch DeltaFormat = "merge-patch" // JSON Merge Patch (RFC 7386), the default
+	DeltaJSONPatch  DeltaFormat = "json-patch"  // JSON Patch (RFC 6902)
+)
+
+// patchOp is a single JSON Patch (RFC 6902) operation
+type patchOp struct {
+	Op    string
+	Path  string
+	From  string
+	Value interface{}
+}
+
+// rawPatchOp is the wire form of a patchOp
+type rawPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// encodePatchOps marshals operations, including the value
+// only for operations which use it, so nulls are explicit.
+func encodePatchOps(codec Codec, ops []patchOp) ([]byte, error) {
+	raw := make([]rawPatchOp, len(ops))
+	for i, o := range ops {
+		raw[i] = rawPatchOp{Op: o.Op, Path: o.Path, From: o.From}
+		switch o.Op {
+		case "add", "replace", "test":
+			b, err := codec.Marshal(o.Value)
+			if err != nil {
+				return nil, err
+			}
+			raw[i].Value = b
+		}
+	}
+	return codec.Marshal(raw)
+}
+
+func decodePatchOps(codec Codec, b []byte) ([]patchOp, error) {
+	var raw []rawPatchOp
+	if err := codec.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	ops := make([]patchOp, len(raw))
+	for i, r := range raw {
+		ops[i] = patchOp{Op: r.Op, Path: r.Path, From: r.From}
+		switch r.Op {
+		case "add", "replace", "test":
+			if len(r.Value) == 0 {
+				return nil, fmt.Errorf("%s %q: missing value", r.Op, r.Path)
+			}
+			if err := codec.Unmarshal(r.Value, &ops[i].Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ops, nil
+}
+
+// KeyFunc identifies the elements of an array, so that array deltas can
+// move, insert and remove elements by key rather than by position. It is
+// given the JSON pointer of the array and an element, and returns false
+// when the element has no key.
+type KeyFunc func(path string, elem interface{}) (key string, ok bool)
+
+// KeyField returns a KeyFunc which identifies objects
+// in any array by the given string or number field.
+func KeyField(field string) KeyFunc {
+	return func(path string, elem interface{}) (string, bool) {
+		obj, ok := elem.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		switch v := obj[field].(type) {
+		case string:
+			return v, true
+		case json.Number:
+			return v.String(), true
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), true
+		}
+		return "", false
+	}
+}
+
+// jsonPatchDiff appends the operations which turn a into b.
+// Arrays are diffed by element, by key when key is non-nil.
+func jsonPatchDiff(ops []patchOp, path string, a, b interface{}, key KeyFunc) []patchOp {
+	if aArr, ok := a.([]interface{}); ok {
+		if bArr, ok := b.([]interface{}); ok {
+			return arrayDiff(ops, path, aArr, bArr, key)
+		}
+	}
+	aObj, aok := a.(map[string]interface{})
+	bObj, bok := b.(map[string]interface{})
+	if !aok || !bok {
+		if !valueEqual(a, b) {
+			ops = append(ops, patchOp{Op: "replace", Path: path, Value: b})
+		}
+		return ops
+	}
+	for _, k := range sortedKeys(aObj) {
+		if _, ok := bObj[k]; !ok {
+			ops = append(ops, patchOp{Op: "remove", Path: path + "/" + escapePointer(k)})
+		}
+	}
+	for _, k := range sortedKeys(bObj) {
+		p := path + "/" + escapePointer(k)
+		if av, ok := aObj[k]; ok {
+			ops = jsonPatchDiff(ops, p, av, bObj[k], key)
+		} else {
+			ops = append(ops, patchOp{Op: "add", Path: p, Value: bObj[k]})
+		}
+	}
+	return ops
+}
+
+// arrayDiff appends the operations which turn array a into b,
+// or a single replace when that would take more operations
+// than there are elements in b.
+func arrayDiff(ops []patchOp, path string, a, b []interface{}, key KeyFunc) []patchOp {
+	if sliceEqual(a, b) {
+		return ops
+	}
+	n := len(ops)
+	keyed := false
+	if key != nil {
+		ops, keyed = keyedArrayDiff(ops, path, a, b, key)
+	}
+	if !keyed {
+		ops = indexedArrayDiff(ops, path, a, b, key)
+	}
+	if len(ops)-n > len(b) {
+		ops = append(ops[:n], patchOp{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+// indexedArrayDiff keeps the common prefix and suffix, diffs
+// the elements in between, then inserts or removes the rest.
+func indexedArrayDiff(ops []patchOp, path string, a, b []interface{}, key KeyFunc) []patchOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && valueEqual(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		valueEqual(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	common := min(len(am), len(bm))
+	for i := 0; i < common; i++ {
+		ops = jsonPatchDiff(ops, path+"/"+strconv.Itoa(prefix+i), am[i], bm[i], key)
+	}
+	for i := common; i < len(bm); i++ {
+		p := path + "/" + strconv.Itoa(prefix+i)
+		if suffix == 0 {
+			p = path + "/-"
+		}
+		ops = append(ops, patchOp{Op: "add", Path: p, Value: bm[i]})
+	}
+	for i := len(am) - 1; i >= common; i-- {
+		ops = append(ops, patchOp{Op: "remove", Path: path + "/" + strconv.Itoa(prefix+i)})
+	}
+	return ops
+}
+
+// keyedArrayDiff removes, moves and inserts elements by key, then diffs
+// the elements which remain. Returns false unless every element of both
+// arrays has a unique key.
+func keyedArrayDiff(ops []patchOp, path string, a, b []interface{}, key KeyFunc) ([]patchOp, bool) {
+	aKeys, ok := arrayKeys(path, a, key)
+	if !ok {
+		return ops, false
+	}
+	bKeys, ok := arrayKeys(path, b, key)
+	if !ok {
+		return ops, false
+	}
+	inB := make(map[string]bool, len(bKeys))
+	for _, k := range bKeys {
+		inB[k] = true
+	}
+	prev := make(map[string]interface{}, len(aKeys))
+	for i, k := range aKeys {
+		prev[k] = a[i]
+	}
+	//remove from the end, so indices remain valid
+	work := make([]string, 0, len(aKeys))
+	for i := len(aKeys) - 1; i >= 0; i-- {
+		if !inB[aKeys[i]] {
+			ops = append(ops, patchOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+	}
+	for _, k := range aKeys {
+		if inB[k] {
+			work = append(work, k)
+		}
+	}
+	//place each element of b in turn
+	for i, k := range bKeys {
+		p := path + "/" + strconv.Itoa(i)
+		if i < len(work) && work[i] == k {
+			ops = jsonPatchDiff(ops, p, prev[k], b[i], key)
+			continue
+		}
+		j := indexOf(work, k, i)
+		if j < 0 {
+			if i == len(work) {
+				ops = append(ops, patchOp{Op: "add", Path: path + "/-", Value: b[i]})
+			} else {
+				ops = append(ops, patchOp{Op: "add", Path: p, Value: b[i]})
+			}
+			work = append(work[:i], append([]string{k}, work[i:]...)...)
+			continue
+		}
+		ops = append(ops, patchOp{Op: "move", From: path + "/" + strconv.Itoa(j), Path: p})
+		work = append(work[:j], work[j+1:]...)
+		work = append(work[:i], append([]string{k}, work[i:]...)...)
+		ops = jsonPatchDiff(ops, p, prev[k], b[i], key)
+	}
+	return ops, true
+}
+
+func arrayKeys(path string, arr []interface{}, key KeyFunc) ([]string, bool) {
+	keys := make([]string, len(arr))
+	seen := make(map[string]bool, len(arr))
+	for i, elem := range arr {
+		k, ok := key(path, elem)
+		if !ok || seen[k] {
+			return nil, false
+		}
+		seen[k] = true
+		keys[i] = k
+	}
+	return keys, true
+}
+
+func indexOf(keys []string, k string, from int) int {
+	for i := from; i < len(keys); i++ {
+		if keys[i] == k {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonPatchBytes diffs two JSON documents into marshalled operations
+func jsonPatchBytes(codec Codec, a, b []byte, key KeyFunc) ([]byte, error) {
+	var av, bv interface{}
+	if err := codec.Unmarshal(a, &av); err != nil {
+		return nil, err
+	}
+	if err := codec.Unmarshal(b, &bv); err != nil {
+		return nil, err
+	}
+	ops := jsonPatchDiff([]patchOp{}, "", av, bv, key)
+	return encodePatchOps(codec, ops)
+}
+
+// applyJSONPatch applies the operations to doc, modifying it
+// in-place where possible, and returns the resulting document.
+func applyJSONPatch(doc interface{}, ops []patchOp) (interface{}, error) {
+	var err error
+	for _, op := range ops {
+		if doc, err = applyPatchOp(doc, op); err != nil {
+			return nil, fmt.Errorf("%s %q: %w", op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOp(doc interface{}, op patchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, path, op.Value)
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+	case "replace":
+		if doc, _, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, op.Value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if op.Op == "move" {
+			doc, v, err = pointerRemove(doc, from)
+		} else {
+			v, err = pointerGet(doc, from)
+			v = copyValue(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, v)
+	case "test":
+		v, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !valueEqual(v, op.Value) {
+			return nil, errors.New("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown operation")
+}
+
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, fmt.Errorf("invalid pointer %q", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= length || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid index %q", token)
+	}
+	return i, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("missing key %q", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot index %T", doc)
+		}
+	}
+	return doc, nil
+}
+
+// pointerUpdate replaces the parent of path using fn, which is given the
+// parent and the last token. Arrays may be reallocated, so the updated
+// document is returned.
+func pointerUpdate(doc interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := pointerGet(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = pointerUpdate(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(node))
+		node[i] = child
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			if token == "-" {
+				return append(node, value), nil
+			}
+			i, err := arrayIndex(token, len(node)+1)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("cannot add to %T", parent)
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	var removed interface{}
+	doc, err := pointerUpdate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("missing key %q", token)
+			}
+			removed = v
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node))
+			if err != nil {
+				return nil, err
+			}
+			removed = node[i]
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove from %T", parent)
+	})
+	return doc, removed, err
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = copyValue(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = copyValue(v)
+		}
+		return s
+	}
+	return v
+}
@@ -0,0 +1,271 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestJSONPatchRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		ops  string
+	}{
+		{"no change", `{"a":1}`, `{"a":1}`, `[]`},
+		{"add", `{}`, `{"a":1}`, `[{"op":"add","path":"/a","value":1}]`},
+		{"remove", `{"a":1}`, `{}`, `[{"op":"remove","path":"/a"}]`},
+		{"explicit null", `{"a":1}`, `{"a":null}`, `[{"op":"replace","path":"/a","value":null}]`},
+		{"nested", `{"o":{"x":1,"y":2}}`, `{"o":{"x":1}}`, `[{"op":"remove","path":"/o/y"}]`},
+		{"append", `{"l":[1,2]}`, `{"l":[1,2,3]}`, `[{"op":"add","path":"/l/-","value":3}]`},
+		{"insert", `{"l":[1,2,3]}`, `{"l":[1,9,2,3]}`, `[{"op":"add","path":"/l/1","value":9}]`},
+		{"truncate", `{"l":[1,2,3,4]}`, `{"l":[1,2]}`, `[{"op":"remove","path":"/l/3"},{"op":"remove","path":"/l/2"}]`},
+		{"element field", `{"l":[{"a":1},{"a":2},{"a":3}]}`, `{"l":[{"a":1},{"a":5},{"a":3}]}`, `[{"op":"replace","path":"/l/1/a","value":5}]`},
+		{"array rewrite", `{"l":[1,2,3]}`, `{"l":[4,5]}`, `[{"op":"replace","path":"/l","value":[4,5]}]`},
+		{"array type change", `{"l":[1]}`, `{"l":{"a":1}}`, `[{"op":"replace","path":"/l","value":{"a":1}}]`},
+		{"escaped", `{}`, `{"a/b~c":1}`, `[{"op":"add","path":"/a~1b~0c","value":1}]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ops, err := velox.JSONPatchBytes([]byte(tc.a), []byte(tc.b))
+			if err != nil {
+				t.Fatalf("diff failed: %v", err)
+			}
+			if string(ops) != tc.ops {
+				t.Fatalf("expected ops %s, got %s", tc.ops, ops)
+			}
+			got, err := velox.ApplyJSONPatch([]byte(tc.a), ops)
+			if err != nil {
+				t.Fatalf("apply failed: %v", err)
+			}
+			assertJSONEqual(t, tc.b, string(got))
+		})
+	}
+}
+
+func TestKeyedJSONPatch(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		ops  string
+	}{
+		{"move", `[{"id":1},{"id":2},{"id":3}]`, `[{"id":3},{"id":1},{"id":2}]`,
+			`[{"op":"move","path":"/0","from":"/2"}]`},
+		{"insert", `[{"id":1},{"id":2}]`, `[{"id":1},{"id":9},{"id":2}]`,
+			`[{"op":"add","path":"/1","value":{"id":9}}]`},
+		{"delete", `[{"id":1},{"id":2},{"id":3}]`, `[{"id":1},{"id":3}]`,
+			`[{"op":"remove","path":"/1"}]`},
+		{"move and change", `[{"id":"a","v":1},{"id":"b","v":1}]`, `[{"id":"b","v":2},{"id":"a","v":1}]`,
+			`[{"op":"move","path":"/0","from":"/1"},{"op":"replace","path":"/0/v","value":2}]`},
+		{"append", `[{"id":1}]`, `[{"id":1},{"id":2}]`,
+			`[{"op":"add","path":"/-","value":{"id":2}}]`},
+		{"duplicate keys by index", `[{"id":1},{"id":1},{"id":2}]`, `[{"id":2},{"id":1},{"id":1}]`,
+			`[{"op":"replace","path":"/0/id","value":2},{"op":"replace","path":"/2/id","value":1}]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ops, err := velox.KeyedJSONPatchBytes([]byte(tc.a), []byte(tc.b), velox.KeyField("id"))
+			if err != nil {
+				t.Fatalf("diff failed: %v", err)
+			}
+			if string(ops) != tc.ops {
+				t.Fatalf("expected ops %s, got %s", tc.ops, ops)
+			}
+			got, err := velox.ApplyJSONPatch([]byte(tc.a), ops)
+			if err != nil {
+				t.Fatalf("apply failed: %v", err)
+			}
+			assertJSONEqual(t, tc.b, string(got))
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := `{"l":[1,2],"o":{"x":1}}`
+	for _, tc := range []struct {
+		name string
+		ops  string
+		want string // empty if an error is expected
+	}{
+		{"insert", `[{"op":"add","path":"/l/1","value":9}]`, `{"l":[1,9,2],"o":{"x":1}}`},
+		{"append", `[{"op":"add","path":"/l/-","value":3}]`, `{"l":[1,2,3],"o":{"x":1}}`},
+		{"remove element", `[{"op":"remove","path":"/l/0"}]`, `{"l":[2],"o":{"x":1}}`},
+		{"move", `[{"op":"move","from":"/o/x","path":"/y"}]`, `{"l":[1,2],"o":{},"y":1}`},
+		{"copy", `[{"op":"copy","from":"/o","path":"/p"}]`, `{"l":[1,2],"o":{"x":1},"p":{"x":1}}`},
+		{"test", `[{"op":"test","path":"/o/x","value":1}]`, doc},
+		{"test failed", `[{"op":"test","path":"/o/x","value":2}]`, ``},
+		{"missing key", `[{"op":"remove","path":"/z"}]`, ``},
+		{"out of range", `[{"op":"replace","path":"/l/2","value":1}]`, ``},
+		{"missing value", `[{"op":"add","path":"/z"}]`, ``},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := velox.ApplyJSONPatch([]byte(doc), []byte(tc.ops))
+			if tc.want == "" {
+				if err == nil {
+					t.Fatalf("expected error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("apply failed: %v", err)
+			}
+			assertJSONEqual(t, tc.want, string(got))
+		})
+	}
+}
+
+func assertJSONEqual(t *testing.T, want, got string) {
+	t.Helper()
+	var w, g any
+	json.Unmarshal([]byte(want), &w)
+	json.Unmarshal([]byte(got), &g)
+	if !reflect.DeepEqual(w, g) {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestJSONPatchSync(t *testing.T) {
+	type Data struct {
+		Name    *string  `json:"name"`
+		Tags    []string `json:"tags"`
+		Padding string   `json:"padding"`
+	}
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Data
+	}
+	name := "initial"
+	test := &TestStruct{Data: Data{
+		Name:    &name,
+		Tags:    []string{"a"},
+		Padding: strings.Repeat("padding which is not changing between versions ", 4),
+	}}
+	test.State.Throttle = 10 * time.Millisecond
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// raw connection negotiating json patches
+	raw := &testClient{url: server.URL + "?format=json-patch"}
+	if err := raw.connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer raw.disconnect()
+	// go client applying them
+	clientData := &struct {
+		sync.Mutex
+		Data
+	}{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.DeltaFormat = velox.DeltaJSONPatch
+	client.Retry = false
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "initial state", func() bool { return client.Version() == 1 })
+	// set an explicit null and change the array
+	test.Lock()
+	test.Name = nil
+	test.Tags = append(test.Tags, "b")
+	test.Unlock()
+	test.Push()
+	for {
+		u, _, err := raw.next()
+		if err != nil {
+			t.Fatalf("Failed to get update: %v", err)
+		}
+		if u.Version < 2 {
+			continue
+		}
+		if !u.Delta || u.Format != velox.DeltaJSONPatch {
+			t.Fatalf("Expected json patch delta, got %+v", u)
+		}
+		assertJSONEqual(t, `[
+			{"op":"replace","path":"/name","value":null},
+			{"op":"add","path":"/tags/-","value":"b"}
+		]`, string(u.Body))
+		break
+	}
+	waitFor(t, "version 2", func() bool { return client.Version() == 2 })
+	clientData.Lock()
+	defer clientData.Unlock()
+	if clientData.Name != nil || !reflect.DeepEqual(clientData.Tags, []string{"a", "b"}) {
+		t.Fatalf("Unexpected client data: %+v", clientData.Data)
+	}
+}
+
+func TestJSONPatchClientResync(t *testing.T) {
+	var queries []url.Values
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query())
+		first := len(queries) == 1
+		mu.Unlock()
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if first {
+			ws.WriteJSON(&velox.Update{ID: "a", Version: 1, Body: []byte(`{"name":"a","count":1}`)})
+			// a patch which does not apply, followed by another delta
+			ws.WriteJSON(&velox.Update{Version: 2, Delta: true, Format: velox.DeltaJSONPatch,
+				Body: []byte(`[{"op":"remove","path":"/missing"}]`)})
+			ws.WriteJSON(&velox.Update{Version: 3, Delta: true, Format: velox.DeltaJSONPatch,
+				Body: []byte(`[{"op":"replace","path":"/count","value":3}]`)})
+		} else {
+			ws.WriteJSON(&velox.Update{ID: "a", Version: 3, Body: []byte(`{"name":"b","count":3}`)})
+		}
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	clientData := &ClientData{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportWebSocket
+	client.DeltaFormat = velox.DeltaJSONPatch
+	client.MinRetryDelay = 10 * time.Millisecond
+	errs := make(chan error, 10)
+	client.OnError = func(err error) { errs <- err }
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "resync", func() bool {
+		clientData.Lock()
+		defer clientData.Unlock()
+		return clientData.Name == "b"
+	})
+	if err := <-errs; !strings.Contains(err.Error(), "failed to apply patch") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the following delta was not applied, the client reconnected for the full state
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 2 || queries[1].Has("v") {
+		t.Fatalf("Expected a reconnect without a version, got %v", queries)
+	}
+	clientData.Lock()
+	defer clientData.Unlock()
+	if clientData.Count != 3 || client.Version() != 3 {
+		t.Fatalf("Unexpected count %d at version %d", clientData.Count, client.Version())
+	}
+}
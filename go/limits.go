@@ -0,0 +1,144 @@
+package velox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrTooManyConnections is returned by Handle when a ConnLimits limit is reached.
+var ErrTooManyConnections = errors.New("velox: too many connections")
+
+// StatusError is returned by Handle when a connection is refused, and by
+// Client.Connect when the server refuses one. ServeHTTP responds with its
+// Code, and a Retry-After header when RetryAfter is set.
+type StatusError struct {
+	Code       int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// write the error as an HTTP response
+func (e *StatusError) write(w http.ResponseWriter) {
+	if e.RetryAfter > 0 {
+		//whole seconds, rounded up
+		w.Header().Set("Retry-After", strconv.Itoa(int((e.RetryAfter+time.Second-1)/time.Second)))
+	}
+	http.Error(w, e.Error(), e.Code)
+}
+
+// responseError describes a refused connection, with the server's retry hint
+func responseError(resp *http.Response) *StatusError {
+	e := &StatusError{Code: resp.StatusCode, Err: fmt.Errorf("unexpected status: %d", resp.StatusCode)}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		e.RetryAfter = time.Duration(s) * time.Second
+	}
+	return e
+}
+
+// ConnLimits restricts the connections Handle accepts. Connections over
+// MaxConns are refused with 503 Service Unavailable, and connections over
+// MaxConnsPerIP or MaxConnsPerKey with 429 Too Many Requests. Zero values
+// are unlimited.
+type ConnLimits struct {
+	// MaxConns limits the connections to the State.
+	MaxConns int
+	// MaxConnsPerIP limits the connections from each remote IP address.
+	// Behind a proxy, use Key to identify clients instead.
+	MaxConnsPerIP int
+	// MaxConnsPerKey limits the connections with each Key.
+	MaxConnsPerKey int
+	// Key optionally identifies the caller of a request, for example by
+	// user id. Requests with an empty key are not limited by MaxConnsPerKey.
+	Key func(r *http.Request) string
+	// RetryAfter is the retry hint sent to refused clients (default 5s).
+	RetryAfter time.Duration
+}
+
+// connCounts are the connections admitted, protected by connMut
+type connCounts struct {
+	total int
+	ips   map[string]int
+	keys  map[string]int
+}
+
+// admit reserves a connection for the request under the Limits, returning
+// a func to release it. Refused requests return a StatusError.
+func (s *State) admit(r *http.Request) (func(), error) {
+	l := s.Limits
+	ip := remoteIP(r)
+	key := ""
+	if l.Key != nil {
+		key = l.Key(r)
+	}
+	s.connMut.Lock()
+	defer s.connMut.Unlock()
+	if s.closing {
+		return nil, &StatusError{Code: http.StatusServiceUnavailable, RetryAfter: s.ShutdownRetry, Err: ErrShutdown}
+	}
+	n := &s.counts
+	var code int
+	var cause error
+	switch {
+	case l.MaxConns > 0 && n.total >= l.MaxConns:
+		code, cause = http.StatusServiceUnavailable, ErrTooManyConnections
+	case l.MaxConnsPerIP > 0 && n.ips[ip] >= l.MaxConnsPerIP:
+		code, cause = http.StatusTooManyRequests, fmt.Errorf("%w from %s", ErrTooManyConnections, ip)
+	case l.MaxConnsPerKey > 0 && key != "" && n.keys[key] >= l.MaxConnsPerKey:
+		code, cause = http.StatusTooManyRequests, fmt.Errorf("%w for key %q", ErrTooManyConnections, key)
+	}
+	if cause != nil {
+		retry := l.RetryAfter
+		if retry == 0 {
+			retry = DefaultLimitRetry
+		}
+		return nil, &StatusError{Code: code, RetryAfter: retry, Err: cause}
+	}
+	if n.ips == nil {
+		n.ips = map[string]int{}
+		n.keys = map[string]int{}
+	}
+	n.total++
+	n.ips[ip]++
+	if key != "" {
+		n.keys[key]++
+	}
+	released := false
+	return func() {
+		s.connMut.Lock()
+		defer s.connMut.Unlock()
+		if released {
+			return
+		}
+		released = true
+		n.total--
+		if n.ips[ip]--; n.ips[ip] == 0 {
+			delete(n.ips, ip)
+		}
+		if key != "" {
+			if n.keys[key]--; n.keys[key] == 0 {
+				delete(n.keys, key)
+			}
+		}
+	}, nil
+}
+
+// remoteIP of the request, without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
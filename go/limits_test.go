@@ -0,0 +1,108 @@
+package velox_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestConnLimits(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Limits = velox.ConnLimits{
+		MaxConns:       3,
+		MaxConnsPerIP:  2,
+		MaxConnsPerKey: 1,
+		Key:            func(r *http.Request) string { return r.Header.Get("X-User") },
+		RetryAfter:     1500 * time.Millisecond,
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	get := func(user string) *http.Response {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("X-User", user)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	expect := func(resp *http.Response, code int) {
+		t.Helper()
+		if resp.StatusCode != code {
+			t.Fatalf("Expected %d, got %d", code, resp.StatusCode)
+		}
+		if code != http.StatusOK {
+			resp.Body.Close()
+			if ra := resp.Header.Get("Retry-After"); ra != "2" {
+				t.Fatalf("Expected Retry-After 2, got %q", ra)
+			}
+		}
+	}
+	a := get("alice")
+	expect(a, http.StatusOK)
+	expect(get("alice"), http.StatusTooManyRequests) // per key
+	b := get("bob")
+	expect(b, http.StatusOK)
+	expect(get("carol"), http.StatusTooManyRequests) // per ip
+	waitFor(t, "connections", func() bool { return test.NumConnections() == 2 })
+	// closed connections are released
+	a.Body.Close()
+	waitFor(t, "release", func() bool { return test.NumConnections() == 1 })
+	a = get("alice")
+	expect(a, http.StatusOK)
+	defer a.Body.Close()
+	b.Body.Close()
+	waitFor(t, "release", func() bool { return test.NumConnections() == 1 })
+	// per state
+	test.State.Limits.MaxConnsPerIP = 0
+	c := get("carol")
+	expect(c, http.StatusOK)
+	defer c.Body.Close()
+	d := get("dave")
+	expect(d, http.StatusOK)
+	defer d.Body.Close()
+	expect(get("erin"), http.StatusServiceUnavailable)
+}
+
+func TestClientRefused(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Limits = velox.ConnLimits{MaxConnsPerIP: 1}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	first := &testClient{id: 1, url: server.URL}
+	if err := first.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer first.disconnect()
+	for _, transport := range []velox.Transport{velox.TransportSSE, velox.TransportWebSocket} {
+		client, err := velox.NewClient(server.URL, &struct {
+			Value string `json:"value"`
+		}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Transport = transport
+		client.Retry = false
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = client.Connect(ctx)
+		cancel()
+		var status *velox.StatusError
+		if !errors.As(err, &status) || status.Code != http.StatusTooManyRequests || status.RetryAfter != velox.DefaultLimitRetry {
+			t.Fatalf("%s: expected 429 with retry hint, got %v", transport, err)
+		}
+	}
+}
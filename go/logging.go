@@ -0,0 +1,42 @@
+package velox
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"sync"
+)
+
+// debugLogger is used when Debug is set without a Logger
+var debugLogger = sync.OnceValue(func() *slog.Logger {
+	return slog.New(slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+})
+
+// discardLogger is used by clients without a Logger
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// logger used by this state: Logger, otherwise slog.Default,
+// or a debug level logger when Debug is set
+func (s *State) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	if s.Debug {
+		return debugLogger()
+	}
+	return slog.Default()
+}
+
+// debugging is true when debug records would be logged,
+// so they are only built when needed
+func debugging(l *slog.Logger) bool {
+	return l.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// logger used by this client: Logger, otherwise discarded
+func (c *Client[T]) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return discardLogger
+}
@@ -0,0 +1,148 @@
+package velox_test
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+// recordHandler keeps each record's message and attributes
+type recordHandler struct {
+	mut     *sync.Mutex
+	records *[]map[string]any
+	attrs   []slog.Attr
+}
+
+func newRecordHandler() *recordHandler {
+	return &recordHandler{mut: &sync.Mutex{}, records: &[]map[string]any{}}
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := map[string]any{"msg": r.Message, "level": r.Level}
+	for _, a := range h.attrs {
+		rec[a.Key] = a.Value.Any()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec[a.Key] = a.Value.Any()
+		return true
+	})
+	h.mut.Lock()
+	*h.records = append(*h.records, rec)
+	h.mut.Unlock()
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &c
+}
+
+func (h *recordHandler) WithGroup(string) slog.Handler { return h }
+
+// find the first record with the given message
+func (h *recordHandler) find(msg string) map[string]any {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for _, r := range *h.records {
+		if r["msg"] == msg {
+			return r
+		}
+	}
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string
+	}
+	serverLog := newRecordHandler()
+	test := &TestStruct{Value: "a"}
+	test.State.Logger = slog.New(serverLog)
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+
+	clientLog := newRecordHandler()
+	data := &struct {
+		sync.Mutex
+		Value string
+	}{}
+	client, err := velox.NewClient(server.URL, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Logger = slog.New(clientLog)
+	client.Retry = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+
+	waitFor(t, "conn send record", func() bool {
+		return serverLog.find("velox: conn send") != nil && clientLog.find("velox: update") != nil
+	})
+	rec := serverLog.find("velox: conn send")
+	if rec["level"] != slog.LevelDebug || rec["version"] != int64(1) || rec["conn"] == nil || rec["addr"] == nil {
+		t.Fatalf("Unexpected server record %v", rec)
+	}
+	if rec := clientLog.find("velox: connected"); rec == nil || rec["transport"] != velox.TransportSSE {
+		t.Fatalf("Unexpected client record %v", rec)
+	}
+	if rec := clientLog.find("velox: update"); rec["version"] != int64(1) || rec["delta"] != false {
+		t.Fatalf("Unexpected client record %v", rec)
+	}
+}
+
+func TestTCPBackplaneLogger(t *testing.T) {
+	// the server logs invalid publications from its clients
+	hub, err := velox.ListenTCPBackplane("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	serverLog := newRecordHandler()
+	hub.Logger = slog.New(serverLog)
+	c, err := net.Dial("tcp", hub.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("not json\n"))
+	waitFor(t, "server record", func() bool { return serverLog.find("velox: backplane server: invalid publication") != nil })
+	if rec := serverLog.find("velox: backplane server: invalid publication"); rec["err"] == nil || rec["addr"] != c.LocalAddr().String() {
+		t.Fatalf("Unexpected record %v", rec)
+	}
+	// as do its clients, from the server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	bp, err := velox.DialTCPBackplane(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bp.Close()
+	clientLog := newRecordHandler()
+	bp.Logger = slog.New(clientLog)
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Write([]byte("not json\n"))
+	waitFor(t, "client record", func() bool { return clientLog.find("velox: backplane: invalid publication") != nil })
+	if rec := clientLog.find("velox: backplane: invalid publication"); rec["err"] == nil || rec["addr"] != l.Addr().String() {
+		t.Fatalf("Unexpected record %v", rec)
+	}
+}
@@ -1,7 +1,6 @@
 package velox
 
 import (
-	"encoding/json"
 	"reflect"
 )
 
@@ -9,14 +8,16 @@ import (
 // double-unmarshal on every push cycle. Instead of unmarshaling both
 // old and new JSON each time, only the new JSON is unmarshaled.
 type mergePatcher struct {
-	prev map[string]interface{}
+	prev  map[string]interface{}
+	codec Codec
 }
 
 // patch computes a merge patch from cached previous state to modifiedJSON.
 // It returns the patch bytes and updates the cache to modifiedJSON.
 func (m *mergePatcher) patch(modifiedJSON []byte) ([]byte, error) {
 	var modified map[string]interface{}
-	if err := json.Unmarshal(modifiedJSON, &modified); err != nil {
+	codec := codecOr(m.codec)
+	if err := codec.Unmarshal(modifiedJSON, &modified); err != nil {
 		return nil, err
 	}
 	var patchBytes []byte
@@ -29,7 +30,7 @@ func (m *mergePatcher) patch(modifiedJSON []byte) ([]byte, error) {
 			patchBytes = []byte(`{}`)
 		} else {
 			var err error
-			patchBytes, err = json.Marshal(diff)
+			patchBytes, err = codec.Marshal(diff)
 			if err != nil {
 				return nil, err
 			}
@@ -120,6 +121,54 @@ func mergeObjects(doc, patch map[string]interface{}) {
 	}
 }
 
+// composePatches merges patch b into patch a in-place, such that applying
+// a then b to orig, the state a applies to, is the same as applying the
+// result. Where b merges into a value which a replaced or deleted, the
+// result is the merged object with b's nulls removed, or when orig had an
+// object there, the diff from that object.
+func composePatches(a, b, orig map[string]interface{}) {
+	for key, bv := range b {
+		bObj, ok := bv.(map[string]interface{})
+		if !ok {
+			// nulls, arrays and values replace regardless of a
+			a[key] = bv
+			continue
+		}
+		av, exists := a[key]
+		if !exists {
+			a[key] = bObj
+			continue
+		}
+		origObj, _ := orig[key].(map[string]interface{})
+		if aObj, ok := av.(map[string]interface{}); ok {
+			composePatches(aObj, bObj, origObj)
+			continue
+		}
+		// b merges into nothing, so the result is a new object
+		obj := withoutNulls(bObj)
+		if origObj != nil {
+			a[key] = objectDiff(origObj, obj)
+		} else {
+			a[key] = obj
+		}
+	}
+}
+
+// withoutNulls returns a copy of patch as it is applied to nothing
+func withoutNulls(patch map[string]interface{}) map[string]interface{} {
+	obj := make(map[string]interface{}, len(patch))
+	for key, v := range patch {
+		switch t := v.(type) {
+		case nil:
+		case map[string]interface{}:
+			obj[key] = withoutNulls(t)
+		default:
+			obj[key] = v
+		}
+	}
+	return obj
+}
+
 func valueEqual(a, b interface{}) bool {
 	if reflect.TypeOf(a) != reflect.TypeOf(b) {
 		return false
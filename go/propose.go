@@ -0,0 +1,126 @@
+package velox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ProposeAction is the reserved action used by Client.Propose
+const ProposeAction = "velox.propose"
+
+// ErrConflict is returned when a proposed change was made against
+// a version which has since been changed in an overlapping way.
+var ErrConflict = errors.New("velox: conflict")
+
+// PatchFunc applies an accepted merge patch (RFC 7386), proposed by the
+// given connection, to the underlying data of a State.
+type PatchFunc func(c Conn, patch json.RawMessage) error
+
+// proposal is the payload of a ProposeAction
+type proposal struct {
+	Base  int64           `json:"base"`
+	Patch json.RawMessage `json:"patch"`
+}
+
+// proposalResult is the reply to an accepted proposal. The
+// change will be included in the next version after Version.
+type proposalResult struct {
+	Version int64 `json:"version"`
+}
+
+// propose applies a client's patch when its base version is current, or when
+// it can be rebased because the changes since its base do not overlap it.
+func (s *State) propose(c Conn, name string, payload json.RawMessage) (any, error) {
+	if s.Patch == nil {
+		return nil, errors.New("proposals are not accepted")
+	}
+	codec := s.codec()
+	p := proposal{}
+	if err := codec.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("invalid proposal: %w", err)
+	}
+	var patch map[string]interface{}
+	if err := codec.Unmarshal(p.Patch, &patch); err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+	//no pushes while checking and applying, the next
+	//push will then be the first to include this change
+	s.push.mut.Lock()
+	s.data.mut.RLock()
+	version := s.data.version
+	conflict := false
+	if p.Base != version {
+		since, ok := s.deltaSince(p.Base)
+		conflict = !ok || patchesOverlap(codec, since, patch)
+	}
+	s.data.mut.RUnlock()
+	if conflict {
+		s.push.mut.Unlock()
+		return nil, ErrConflict
+	}
+	err := s.Patch(c, p.Patch)
+	s.push.mut.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	s.Push()
+	return &proposalResult{Version: version}, nil
+}
+
+// patchesOverlap returns true if the merge patch delta and
+// patch b change the same value, or one changes a parent of the other.
+func patchesOverlap(codec Codec, delta []byte, b map[string]interface{}) bool {
+	var a map[string]interface{}
+	if err := codec.Unmarshal(delta, &a); err != nil {
+		return true
+	}
+	return objectsOverlap(a, b)
+}
+
+func objectsOverlap(a, b map[string]interface{}) bool {
+	for key, bv := range b {
+		av, ok := a[key]
+		if !ok {
+			continue
+		}
+		aObj, aok := av.(map[string]interface{})
+		bObj, bok := bv.(map[string]interface{})
+		if !aok || !bok || objectsOverlap(aObj, bObj) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergePatch returns a PatchFunc which merges accepted patches into
+// gostruct, then replaces its contents like Unmarshal, while locked.
+// VMap and VSlice fields are rebound to the embedded State, if any.
+func MergePatch(gostruct interface{}) PatchFunc {
+	locker, pusher := structLocker(gostruct)
+	return func(c Conn, patch json.RawMessage) error {
+		codec := codecOf(gostruct)
+		var patchMap map[string]interface{}
+		if err := codec.Unmarshal(patch, &patchMap); err != nil {
+			return err
+		}
+		if locker != nil {
+			locker.Lock()
+			defer locker.Unlock()
+		}
+		b, err := codec.Marshal(gostruct)
+		if err != nil {
+			return err
+		}
+		doc := map[string]interface{}{}
+		if err := codec.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		mergeObjects(doc, patchMap)
+		merged, err := codec.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return unmarshalLocked(gostruct, merged, locker, pusher)
+	}
+}
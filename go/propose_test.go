@@ -0,0 +1,136 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// proposeServer serves data over bufconn with proposals
+// enabled, returning a connected websocket client.
+func proposeServer(t *testing.T, ctx context.Context, data *ServerData) (*velox.Client[ClientData], *ClientData) {
+	data.State.Throttle = 10 * time.Millisecond
+	handler := velox.SyncHandler(data)
+	data.State.Patch = velox.MergePatch(data)
+	l := bufconn.Listen(64 * 1024)
+	t.Cleanup(func() { l.Close() })
+	server := &http.Server{Handler: handler}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	clientData := &ClientData{}
+	client, err := velox.NewClient("http://bufconn/sync", clientData)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	client.HTTPClient = bufconnClient(l)
+	client.Transport = velox.TransportWebSocket
+	client.Retry = false
+	go client.Connect(ctx)
+	t.Cleanup(client.Disconnect)
+	waitFor(t, "initial state", func() bool { return client.Version() > 0 })
+	return client, clientData
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestClientPropose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverData := &ServerData{Name: "initial", Count: 1}
+	client, clientData := proposeServer(t, ctx, serverData)
+
+	if err := client.Propose(ctx, map[string]any{"name": "proposed"}); err != nil {
+		t.Fatalf("Propose failed: %v", err)
+	}
+	// applied locally before the server confirms
+	clientData.Lock()
+	name := clientData.Name
+	clientData.Unlock()
+	if name != "proposed" {
+		t.Fatalf("Expected local name 'proposed', got %q", name)
+	}
+	serverData.Lock()
+	name = serverData.Name
+	serverData.Unlock()
+	if name != "proposed" {
+		t.Fatalf("Expected server name 'proposed', got %q", name)
+	}
+	// and then confirmed by the next version
+	waitFor(t, "version 2", func() bool { return client.Version() == 2 })
+	clientData.Lock()
+	name, count := clientData.Name, clientData.Count
+	clientData.Unlock()
+	if name != "proposed" || count != 1 {
+		t.Fatalf("Expected proposed/1, got %s/%d", name, count)
+	}
+}
+
+func TestClientProposeRollback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverData := &ServerData{Name: "initial"}
+	client, clientData := proposeServer(t, ctx, serverData)
+	serverData.State.Patch = func(c velox.Conn, patch json.RawMessage) error {
+		return errors.New("read only")
+	}
+	var actionErr *velox.ActionError
+	if err := client.Propose(ctx, map[string]any{"name": "proposed"}); !errors.As(err, &actionErr) {
+		t.Fatalf("Expected ActionError, got %v", err)
+	}
+	clientData.Lock()
+	name := clientData.Name
+	clientData.Unlock()
+	if name != "initial" {
+		t.Fatalf("Expected rollback to 'initial', got %q", name)
+	}
+}
+
+func TestProposeConflict(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serverData := &ServerData{Name: "initial"}
+	client, _ := proposeServer(t, ctx, serverData)
+	base := client.Version()
+	// server changes the name
+	serverData.Lock()
+	serverData.Name = "server"
+	serverData.Unlock()
+	serverData.Push()
+	waitFor(t, "server change", func() bool { return client.Version() == base+1 })
+	propose := func(patch string) error {
+		return client.Call(ctx, velox.ProposeAction, map[string]any{
+			"base":  base,
+			"patch": json.RawMessage(patch),
+		}, nil)
+	}
+	// overlapping change from the old version is rejected
+	if err := propose(`{"name":"client"}`); !errors.Is(err, velox.ErrConflict) {
+		t.Fatalf("Expected ErrConflict, got %v", err)
+	}
+	// disjoint change from the old version is rebased
+	if err := propose(`{"count":5}`); err != nil {
+		t.Fatalf("Expected rebase, got %v", err)
+	}
+	serverData.Lock()
+	name, count := serverData.Name, serverData.Count
+	serverData.Unlock()
+	if name != "server" || count != 5 {
+		t.Fatalf("Expected server/5, got %s/%d", name, count)
+	}
+}
@@ -0,0 +1,96 @@
+package velox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrConnClosed is reported for connections which closed
+// before receiving a version
+var ErrConnClosed = errors.New("velox: connection closed")
+
+// Delivery is the outcome of sending a version to one connection
+type Delivery struct {
+	Conn Conn
+	Err  error // nil when the version was sent
+}
+
+// Timeout is true when the send timed out or the context expired
+func (d Delivery) Timeout() bool {
+	return errors.Is(d.Err, ErrSendTimeout) || errors.Is(d.Err, context.DeadlineExceeded)
+}
+
+// PushResult reports the delivery of a version by PushAndWait
+type PushResult struct {
+	Version    int64
+	Deliveries []Delivery // one per connection open at the time of the push
+}
+
+// Failed returns the deliveries which did not complete
+func (r *PushResult) Failed() []Delivery {
+	var failed []Delivery
+	for _, d := range r.Deliveries {
+		if d.Err != nil {
+			failed = append(failed, d)
+		}
+	}
+	return failed
+}
+
+// Err combines the errors of the failed deliveries, or is nil
+// when every connection received the version
+func (r *PushResult) Err() error {
+	var errs []error
+	for _, d := range r.Failed() {
+		errs = append(errs, fmt.Errorf("conn %s: %w", d.Conn.ID(), d.Err))
+	}
+	return errors.Join(errs...)
+}
+
+// PushAndWait pushes the current data, like Push, then blocks until the
+// resulting version has been sent to every connection open at the time,
+// or the context expires. Unlike Push, it waits for any push in progress
+// rather than coalescing with it. An error is returned when the data
+// could not be pushed; failed deliveries are reported in the result.
+func (s *State) PushAndWait(ctx context.Context) (*PushResult, error) {
+	if s.Data == nil {
+		return nil, errors.New("velox: no data function provided")
+	}
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	s.push.mut.Lock()
+	t0 := time.Now()
+	version, err := s.pushLocked(t0)
+	//release the push lock once the throttle has passed
+	go func() {
+		if t := s.Throttle - time.Since(t0); t > 0 {
+			time.Sleep(t)
+		}
+		s.push.mut.Unlock()
+	}()
+	if err != nil {
+		return nil, err
+	}
+	s.connMut.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connMut.Unlock()
+	result := &PushResult{
+		Version:    version,
+		Deliveries: make([]Delivery, len(conns)),
+	}
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Go(func() {
+			result.Deliveries[i] = Delivery{Conn: c, Err: c.waitVersion(ctx, version)}
+		})
+	}
+	wg.Wait()
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestPushAndWait(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.Throttle = 20 * time.Millisecond
+	// a stalled view blocks sends to the "slow" connection
+	var stall atomic.Bool
+	release := make(chan struct{})
+	test.State.Filter = func(c velox.Conn, r *http.Request, data json.RawMessage) (json.RawMessage, error) {
+		if r.URL.Query().Has("slow") && stall.Load() {
+			<-release
+		}
+		return data, nil
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	fast := &testClient{id: 1, url: server.URL}
+	slow := &testClient{id: 2, url: server.URL + "?slow=1"}
+	for _, c := range []*testClient{fast, slow} {
+		if err := c.connect(t.Context()); err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer c.disconnect()
+		c.next() // ping
+		c.next() // initial state
+	}
+	// all connections receive the version
+	test.Lock()
+	test.Value = "b"
+	test.Unlock()
+	result, err := test.PushAndWait(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Version != 2 || len(result.Deliveries) != 2 || result.Err() != nil {
+		t.Fatalf("Unexpected result %+v: %v", result, result.Err())
+	}
+	for _, c := range []*testClient{fast, slow} {
+		if u, _, err := c.next(); err != nil || u.Version != 2 {
+			t.Fatalf("Expected version 2, got %+v: %v", u, err)
+		}
+	}
+	// the stalled connection times out
+	stall.Store(true)
+	test.Lock()
+	test.Value = "c"
+	test.Unlock()
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	result, err = test.PushAndWait(ctx)
+	close(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Version != 3 {
+		t.Fatalf("Expected version 3, got %d", result.Version)
+	}
+	failed := result.Failed()
+	if len(failed) != 1 || !failed[0].Timeout() || result.Err() == nil {
+		t.Fatalf("Expected one timeout, got %+v", result.Deliveries)
+	}
+	if u, _, err := fast.next(); err != nil || u.Version != 3 {
+		t.Fatalf("Expected version 3, got %+v: %v", u, err)
+	}
+	// unchanged data is already delivered
+	result, err = test.PushAndWait(t.Context())
+	if err != nil || result.Version != 3 || result.Err() != nil {
+		t.Fatalf("Unexpected result %+v: %v", result, err)
+	}
+}
@@ -0,0 +1,90 @@
+package velox
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrShutdown is returned by Handle once Shutdown has been called, wrapped
+// in a StatusError, and by Client.Connect when the server shuts down and
+// Retry is disabled.
+var ErrShutdown = errors.New("velox: shutting down")
+
+// Shutdown stops accepting connections, pushes the latest version to
+// every connection and waits for it to be saved to the Store, if any,
+// marked clean so that a restarted State keeps its id,
+// then says goodbye to each client, asking it to
+// reconnect after a random delay of up to ShutdownRetry. It returns
+// once every connection has closed, or closes the remainder when the
+// context expires and returns the context's error.
+func (s *State) Shutdown(ctx context.Context) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	s.connMut.Lock()
+	s.closing = true
+	s.connMut.Unlock()
+	if s.backplane.cancel != nil {
+		s.backplane.cancel()
+	}
+	//flush the latest version
+	if s.Data != nil {
+		if _, err := s.PushAndWait(ctx); err != nil {
+			s.logger().Error("velox: shutdown push failed", "err", err)
+		}
+	}
+	s.saveAs(true)
+	if err := s.saved(ctx); err != nil {
+		s.logger().Error("velox: shutdown save failed", "err", err)
+	}
+	s.connMut.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connMut.Unlock()
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Go(func() {
+			c.goodbye(s.retryHint())
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		for _, c := range conns {
+			c.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, c := range conns {
+			c.Close()
+		}
+		return ctx.Err()
+	}
+}
+
+// retryHint picks a reconnect delay, spreading out clients
+func (s *State) retryHint() time.Duration {
+	if s.ShutdownRetry <= 0 {
+		return 0
+	}
+	return rand.N(s.ShutdownRetry)
+}
+
+// goodbye tells the client to reconnect after the
+// given delay, then closes the connection
+func (c *conn) goodbye(retry time.Duration) {
+	upd := &Update{Goodbye: true, Retry: max(retry.Milliseconds(), 1)}
+	if err := c.send(upd); err != nil {
+		c.log.Debug("velox: goodbye failed", "err", err)
+	}
+	c.Close()
+}
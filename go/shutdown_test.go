@@ -0,0 +1,129 @@
+package velox_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestShutdown(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Value string `json:"value"`
+	}
+	test := &TestStruct{Value: "a"}
+	test.State.ShutdownRetry = 200 * time.Millisecond
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	// a raw eventsource client
+	raw := &testClient{id: 1, url: server.URL}
+	if err := raw.connect(t.Context()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer raw.disconnect()
+	raw.next() // ping
+	raw.next() // initial state
+	// a go client, without retries
+	clientData := &struct {
+		sync.Mutex
+		Value string `json:"value"`
+	}{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Retry = false
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connectErr := make(chan error, 1)
+	go func() { connectErr <- client.Connect(ctx) }()
+	waitFor(t, "client", func() bool { return client.Version() == 1 })
+	// changed, but not pushed
+	test.Lock()
+	test.Value = "b"
+	test.Unlock()
+	if err := test.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if n := test.NumConnections(); n != 0 {
+		t.Fatalf("Expected no connections, got %d", n)
+	}
+	// the latest version is flushed before goodbye
+	if u, _, err := raw.next(); err != nil || u.Version != 2 {
+		t.Fatalf("Expected version 2, got %+v: %v", u, err)
+	}
+	u, _, err := raw.next()
+	if err != nil || !u.Goodbye || u.Retry < 1 || u.Retry > 200 {
+		t.Fatalf("Expected goodbye, got %+v: %v", u, err)
+	}
+	if err := <-connectErr; !errors.Is(err, velox.ErrShutdown) {
+		t.Fatalf("Expected ErrShutdown, got %v", err)
+	}
+	if clientData.Value != "b" {
+		t.Fatalf("Expected flushed value b, got %s", clientData.Value)
+	}
+	// new connections are refused
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("Expected 503 with Retry-After, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestShutdownClientRetry(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		Value string `json:"value"`
+	}
+	// two instances behind one address, as in a rolling deploy
+	old := &TestStruct{Value: "old"}
+	old.State.ShutdownRetry = 50 * time.Millisecond
+	next := &TestStruct{Value: "new"}
+	var mut sync.Mutex
+	current := velox.SyncHandler(old)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		h := current
+		mut.Unlock()
+		h.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	clientData := &struct {
+		sync.Mutex
+		Value string `json:"value"`
+	}{}
+	client, err := velox.NewClient(server.URL, clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = velox.TransportWebSocket
+	client.MinRetryDelay = 5 * time.Second // goodbye retries sooner
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go client.Connect(ctx)
+	defer client.Disconnect()
+	waitFor(t, "old", func() bool { return client.Version() == 1 && client.ID() == old.ID() })
+	mut.Lock()
+	current = velox.SyncHandler(next)
+	mut.Unlock()
+	if err := old.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	waitFor(t, "new", func() bool {
+		clientData.Lock()
+		defer clientData.Unlock()
+		return clientData.Value == "new"
+	})
+}
@@ -0,0 +1,150 @@
+package velox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// SlowConsumerPolicy decides how connections which cannot keep up are
+// handled. Writes to a connection are bounded by a write deadline, and
+// a write which exceeds it leaves the client with a partial update, so
+// the connection is dropped. State pushes to a connection which is still
+// writing wait up to WriteTimeout for it, and a push which waits longer
+// times out. Pushes made in the meantime are coalesced into one update
+// of the latest version, sent once the write completes. Action replies,
+// events and pings cannot be retried, so they wait for the write in
+// progress, however long its deadline allows.
+type SlowConsumerPolicy struct {
+	// SkipToLatest skips state pushes to a connection while a write to
+	// it is in progress, rather than waiting up to WriteTimeout for it.
+	SkipToLatest bool
+	// MaxTimeouts is the number of write timeouts a connection may stall
+	// for: each write's deadline is MaxTimeouts times WriteTimeout, and a
+	// connection is dropped after this many consecutive push timeouts
+	// (default: 1, the first timeout).
+	MaxTimeouts int
+	// MaxPendingBytes drops a connection as soon as a push times out
+	// waiting for a write larger than this (default: unlimited).
+	MaxPendingBytes int
+}
+
+// writeDeadline is the time allowed for each write
+func (p SlowConsumerPolicy) writeDeadline(timeout time.Duration) time.Duration {
+	return timeout * time.Duration(max(p.MaxTimeouts, 1))
+}
+
+// errWriteBusy is returned when a state push is skipped because
+// a write is still in progress, and is never returned to callers
+var errWriteBusy = errors.New("velox: write in progress")
+
+// deadlineExceeded wraps a write error caused by the write deadline
+// as a send timeout, otherwise it is returned as is
+func deadlineExceeded(err error) error {
+	var ne net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrSendTimeout, err)
+	}
+	if errors.As(err, &ne) && ne.Timeout() {
+		//websocket errors hide their cause
+		return fmt.Errorf("%w: %w (%s)", ErrSendTimeout, os.ErrDeadlineExceeded, err)
+	}
+	return err
+}
+
+// dropSlow applies the SlowConsumer policy to a failed send,
+// returning true when the connection should be closed
+func (c *conn) dropSlow(err error) bool {
+	if errors.Is(err, errWriteBusy) {
+		return false
+	}
+	if !errors.Is(err, ErrSendTimeout) {
+		return true
+	}
+	p := c.state.SlowConsumer
+	timeouts := c.timeouts.Load()
+	pending := c.writer.pendingBytes()
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		//the client has a partial update
+		err = fmt.Errorf("%w (after %s)", err, p.writeDeadline(c.state.WriteTimeout))
+	case timeouts >= int32(max(p.MaxTimeouts, 1)):
+		err = fmt.Errorf("%w (%d consecutive)", err, timeouts)
+	case p.MaxPendingBytes > 0 && pending > p.MaxPendingBytes:
+		err = fmt.Errorf("%w (%d bytes pending)", err, pending)
+	default:
+		//not sent, the write in progress may still complete
+		c.log.Warn("velox: slow connection", "timeouts", timeouts, "pending", pending)
+		return false
+	}
+	c.state.hookDrop(c, err)
+	return true
+}
+
+// writeGate serialises the writes to a connection. Unlike a mutex,
+// a send can give up waiting for the write in progress.
+type writeGate struct {
+	token  chan struct{} // holds a token while a write is in progress
+	bytes  atomic.Int64  // size of the write in progress
+	missed atomic.Bool   // a send gave up during the write in progress
+	idle   func()        // called after a write which a send gave up on
+}
+
+func newWriteGate(idle func()) *writeGate {
+	return &writeGate{token: make(chan struct{}, 1), idle: idle}
+}
+
+// acquire the gate for a write of n bytes, waiting up to timeout for
+// the write in progress, or with skip, not waiting. Call done once
+// the write completes.
+func (g *writeGate) acquire(timeout time.Duration, skip bool, n int) error {
+	select {
+	case g.token <- struct{}{}:
+	default:
+		if skip {
+			g.missed.Store(true)
+			return errWriteBusy
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case g.token <- struct{}{}:
+		case <-timer.C:
+			g.missed.Store(true)
+			return ErrSendTimeout
+		}
+	}
+	g.bytes.Store(int64(n))
+	return nil
+}
+
+// wait for the gate for a write of n bytes, however long the write in
+// progress takes, unless the connection closes first. Call done once
+// the write completes.
+func (g *writeGate) wait(closed <-chan struct{}, n int) error {
+	select {
+	case g.token <- struct{}{}:
+	case <-closed:
+		return ErrConnClosed
+	}
+	g.bytes.Store(int64(n))
+	return nil
+}
+
+// done releases the gate after a write. Once a write succeeds,
+// pushes missed while it was in progress are retried.
+func (g *writeGate) done(err error) {
+	g.bytes.Store(0)
+	<-g.token
+	if err == nil && g.missed.Swap(false) && g.idle != nil {
+		g.idle()
+	}
+}
+
+// pendingBytes is the size of the write in progress
+func (g *writeGate) pendingBytes() int {
+	return int(g.bytes.Load())
+}
@@ -0,0 +1,258 @@
+package velox_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	velox "github.com/jpillora/velox/go"
+)
+
+// blob is incompressible, so it quickly fills a stalled connection
+func blob(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+type slowState struct {
+	velox.State
+	sync.Mutex
+	Blob string `json:"blob"`
+}
+
+// pushBlobs until done returns true
+func pushBlobs(t *testing.T, s *slowState, done func() bool) {
+	t.Helper()
+	for i := 0; !done(); i++ {
+		if i == 50 {
+			t.Fatal("Timed out pushing")
+		}
+		s.Lock()
+		s.Blob = blob(1 << 20)
+		s.Unlock()
+		ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+		_, err := s.PushAndWait(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// stalled is true once a push is not delivered,
+// even after the write timeout has passed twice
+func stalled(s *slowState) func() bool {
+	return func() bool {
+		time.Sleep(2 * s.State.WriteTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		result, err := s.PushAndWait(ctx)
+		return err == nil && result.Err() != nil
+	}
+}
+
+// stalledClient connects over websockets and does not read
+func stalledClient(t *testing.T, url string) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	return ws
+}
+
+func TestSlowConsumerDrop(t *testing.T) {
+	test := &slowState{}
+	test.State.Throttle = velox.MinThrottle
+	test.State.WriteTimeout = 50 * time.Millisecond
+	test.State.SlowConsumer = velox.SlowConsumerPolicy{MaxTimeouts: 3}
+	var timedOut atomic.Int64
+	var dropErr atomic.Value
+	test.State.Hooks = &velox.Hooks{
+		OnSend: func(info velox.SendInfo) {
+			if info.Timeout() {
+				timedOut.CompareAndSwap(0, int64(info.Duration))
+			}
+		},
+		OnDrop: func(c velox.Conn, transport velox.Transport, err error) {
+			dropErr.CompareAndSwap(nil, err)
+		},
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ws := stalledClient(t, server.URL)
+	defer ws.Close()
+	waitFor(t, "connection", func() bool { return test.NumConnections() == 1 })
+	pushBlobs(t, test, func() bool { return dropErr.Load() != nil })
+	// the write was aborted by its deadline, after 3 write timeouts
+	if err := dropErr.Load().(error); !errors.Is(err, velox.ErrSendTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Unexpected drop error: %v", err)
+	}
+	if d := time.Duration(timedOut.Load()); d < 3*test.State.WriteTimeout {
+		t.Fatalf("Expected the write to take 3 write timeouts, took %s", d)
+	}
+	waitFor(t, "disconnect", func() bool { return test.NumConnections() == 0 })
+}
+
+func TestSlowConsumerSkipToLatest(t *testing.T) {
+	test := &slowState{}
+	test.State.Throttle = velox.MinThrottle
+	test.State.WriteTimeout = 50 * time.Millisecond
+	test.State.SlowConsumer = velox.SlowConsumerPolicy{SkipToLatest: true, MaxTimeouts: 1000}
+	test.State.Hooks = &velox.Hooks{
+		OnDrop: func(c velox.Conn, transport velox.Transport, err error) {
+			t.Errorf("Unexpected drop: %v", err)
+		},
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ws := stalledClient(t, server.URL)
+	defer ws.Close()
+	waitFor(t, "connection", func() bool { return test.NumConnections() == 1 })
+	// once a write stalls, pushes are coalesced rather than waiting for it
+	pushBlobs(t, test, stalled(test))
+	before := test.Version()
+	for range 3 {
+		test.Lock()
+		test.Blob = blob(1 << 10)
+		test.Unlock()
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		test.PushAndWait(ctx)
+		cancel()
+	}
+	// once the client reads again, the pushes since are coalesced into the latest version
+	latest := test.Version()
+	var since []int64
+	for {
+		u := &velox.Update{}
+		if err := ws.ReadJSON(u); err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if u.Version > before {
+			since = append(since, u.Version)
+		}
+		if u.Version == latest {
+			break
+		}
+	}
+	if len(since) != 1 {
+		t.Fatalf("Expected only version %d since the stall, got %v", latest, since)
+	}
+}
+
+func TestSlowConsumerSkipOnlyPushes(t *testing.T) {
+	test := &slowState{}
+	test.State.Throttle = velox.MinThrottle
+	test.State.WriteTimeout = 50 * time.Millisecond
+	test.State.SlowConsumer = velox.SlowConsumerPolicy{SkipToLatest: true, MaxTimeouts: 1000}
+	test.HandleAction("echo", func(c velox.Conn, name string, payload json.RawMessage) (any, error) {
+		return payload, nil
+	})
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	ws := stalledClient(t, server.URL)
+	defer ws.Close()
+	waitFor(t, "connection", func() bool { return test.NumConnections() == 1 })
+	pushBlobs(t, test, stalled(test))
+	// a write has stalled, the event and the reply wait for it
+	broadcast := make(chan error, 1)
+	go func() {
+		broadcast <- test.Broadcast("hello", "world")
+	}()
+	if err := ws.WriteJSON(&velox.Message{ID: 7, Action: "echo", Payload: json.RawMessage(`"hi"`)}); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	// once the client reads again, both arrive
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event, reply bool
+	for !event || !reply {
+		u := &velox.Update{}
+		if err := ws.ReadJSON(u); err != nil {
+			t.Fatalf("Failed to read (event %v, reply %v): %v", event, reply, err)
+		}
+		if u.Event == "hello" {
+			event = true
+		}
+		if u.Reply == 7 {
+			reply = true
+			if string(u.Payload) != `"hi"` {
+				t.Fatalf("Unexpected reply: %s", u.Payload)
+			}
+		}
+	}
+	if err := <-broadcast; err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+}
+
+func TestSSEWriteDeadline(t *testing.T) {
+	test := &slowState{}
+	test.State.Throttle = velox.MinThrottle
+	test.State.WriteTimeout = 50 * time.Millisecond
+	var dropErr atomic.Value
+	test.State.Hooks = &velox.Hooks{
+		OnDrop: func(c velox.Conn, transport velox.Transport, err error) {
+			dropErr.Store(err)
+		},
+	}
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	// an eventsource client which does not read
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: velox\r\nAccept: text/event-stream\r\n\r\n")
+	waitFor(t, "connection", func() bool { return test.NumConnections() == 1 })
+	pushBlobs(t, test, func() bool { return dropErr.Load() != nil })
+	// the write was aborted by its deadline
+	if err := dropErr.Load().(error); !errors.Is(err, velox.ErrSendTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Unexpected drop error: %v", err)
+	}
+	waitFor(t, "disconnect", func() bool { return test.NumConnections() == 0 })
+}
+
+func TestSSEWriteDeadlineIdleHTTP2(t *testing.T) {
+	test := &slowState{Blob: "a"}
+	test.State.Throttle = velox.MinThrottle
+	test.State.WriteTimeout = 50 * time.Millisecond
+	server := httptest.NewUnstartedServer(velox.SyncHandler(test))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	client := &testClient{url: server.URL, http: server.Client()}
+	if err := client.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.disconnect()
+	client.next() // ping
+	if _, _, err := client.next(); err != nil {
+		t.Fatal(err)
+	}
+	// idle for longer than the write timeout
+	time.Sleep(4 * test.State.WriteTimeout)
+	test.Lock()
+	test.Blob = "b"
+	test.Unlock()
+	test.Push()
+	update, _, err := client.next()
+	if err != nil {
+		t.Fatalf("Expected the stream to stay open: %v", err)
+	}
+	if update.Version != 2 {
+		t.Fatalf("Expected version 2, got %d", update.Version)
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"sync"
@@ -29,6 +30,12 @@ var (
 	DefaultWriteTimeout = 30 * time.Second
 	//DefaultPingInterval is the default State.PingInterval value.
 	DefaultPingInterval = 25 * time.Second
+	//DefaultAckTimeout is the default State.AckTimeout value.
+	DefaultAckTimeout = 30 * time.Second
+	//DefaultShutdownRetry is the default State.ShutdownRetry value.
+	DefaultShutdownRetry = 5 * time.Second
+	//DefaultLimitRetry is the default ConnLimits.RetryAfter value.
+	DefaultLimitRetry = 5 * time.Second
 )
 
 // State must be embedded into a struct to make it syncable.
@@ -39,24 +46,89 @@ type State struct {
 	Throttle     time.Duration `json:"-"` // Throttle is the minimum time between pushes.
 	WriteTimeout time.Duration `json:"-"` // WriteTimeout is the maximum time to wait for a write to complete.
 	PingInterval time.Duration `json:"-"` // PingInterval is the time between pings to the client.
-	Debug        bool          `json:"-"` // Debug is used to enable debug logging.
+	AckTimeout   time.Duration `json:"-"` // AckTimeout is the time a client may leave a version unacknowledged.
+	Debug        bool          `json:"-"` // Debug enables debug logging when Logger is not set.
+	Logger       *slog.Logger  `json:"-"` // Logger optionally replaces slog.Default.
+	Filter       FilterFunc    `json:"-"` // Filter optionally projects the state sent to each connection.
+	Patch        PatchFunc     `json:"-"` // Patch optionally accepts changes proposed by clients.
+	Authorize    AuthorizeFunc `json:"-"` // Authorize optionally decides which requests may connect.
+	Store        Store         `json:"-"` // Store optionally persists the state across restarts.
+	// Backplane optionally shares new versions with other instances of
+	// this State. Instances without Data only relay received versions.
+	Backplane      Backplane `json:"-"`
+	BackplaneTopic string    `json:"-"` // BackplaneTopic identifies this State on the Backplane.
+	// Unmarshal optionally writes versions received from the Backplane
+	// into the data, so that local pushes build on them. SyncHandler sets
+	// it for structs which embed State.
+	Unmarshal UnmarshalFunc `json:"-"`
+	// ShutdownRetry is the maximum time clients are asked to wait before
+	// reconnecting after Shutdown, each picks a random delay within it.
+	ShutdownRetry time.Duration `json:"-"`
+	// DeltaHistory is the number of recent deltas kept, so clients which
+	// reconnect a few versions behind receive one combined delta. The
+	// state the oldest delta applies to is kept with them, decoded.
+	// Zero keeps only the latest delta.
+	DeltaHistory int `json:"-"`
+	// DeltaHistoryBytes optionally limits the total size of DeltaHistory.
+	DeltaHistoryBytes int `json:"-"`
+	// ArrayKey optionally identifies array elements in JSON Patch deltas,
+	// so reordered elements are moved rather than resent. See KeyField.
+	ArrayKey KeyFunc `json:"-"`
+	// Codec optionally replaces encoding/json for marshalling and diffing.
+	Codec Codec `json:"-"`
+	// SlowConsumer decides how connections which cannot keep up are handled.
+	SlowConsumer SlowConsumerPolicy `json:"-"`
+	// Limits optionally restricts the connections accepted by Handle.
+	Limits ConnLimits `json:"-"`
+	// Hooks optionally observe pushes, sends and connections.
+	Hooks *Hooks `json:"-"`
+	// Encodings are the binary encodings offered to WebSocket clients
+	// (default: DefaultEncodings). Set an empty slice to only use JSON.
+	Encodings []Encoding `json:"-"`
 	//internal state
-	initMut sync.Mutex
-	initd   bool
-	connMut sync.Mutex
-	conns   map[int64]*conn
-	data struct {
+	initMut   sync.Mutex
+	initd     bool
+	connMut   sync.Mutex
+	conns     map[int64]*conn
+	closing   bool       // Shutdown called, protected by connMut
+	counts    connCounts // admitted connections, protected by connMut
+	actionMut sync.Mutex
+	actions   map[string]ActionFunc
+	data      struct {
 		mut     sync.RWMutex
 		id      string //data id != conn id
+		origin  string //instance which made the version, see receivePublication
 		bytes   []byte
 		delta   []byte
 		version int64
 		patcher mergePatcher // caches unmarshaled prev state
+		history deltaHistory
+		prev    []byte    // bytes of version-1, for json patches
+		changed time.Time // when version was created
+		ops     struct {
+			mut     sync.Mutex
+			version int64
+			bytes   []byte
+		}
+	}
+	wire      wireCache // encoded updates, shared by websocket connections
+	backplane struct {
+		origin string // identifies this instance
+		cancel func()
+	}
+	store struct {
+		once     sync.Once
+		restored *Checkpoint
+		mut      sync.Mutex
+		dirty    bool          // a newer version is waiting to be saved
+		clean    bool          // the next save is marked clean
+		idle     chan struct{} // closed once saving stops, nil when not saving
 	}
 	push struct {
 		mut    sync.Mutex
 		ing    uint32
 		queued uint32
+		last   atomic.Int64 // unix nanoseconds of the last push
 	}
 }
 
@@ -76,37 +148,92 @@ func (s *State) init() error {
 	if s.PingInterval == 0 {
 		s.PingInterval = DefaultPingInterval
 	}
-	if s.Data == nil {
+	if s.AckTimeout == 0 {
+		s.AckTimeout = DefaultAckTimeout
+	}
+	if s.ShutdownRetry == 0 {
+		s.ShutdownRetry = DefaultShutdownRetry
+	}
+	if s.Encodings == nil {
+		s.Encodings = DefaultEncodings
+	}
+	s.data.patcher.codec = s.codec()
+	s.data.changed = time.Now()
+	if s.Data == nil && s.Backplane == nil {
 		return fmt.Errorf("no data function provided")
 	}
 	//get initial JSON bytes and confirm gostruct is marshallable
-	b, _ := s.Data()
+	var b []byte
+	if s.Data != nil {
+		b, _ = s.Data()
+	}
 	// set data fields
 	s.data.mut.Lock()
-	s.data.bytes = b
-	// seed the merge patcher cache with the initial state
-	s.data.patcher.patch(b)
-	id := make([]byte, 4)
-	if n, _ := rand.Read(id); n > 0 {
-		s.data.id = hex.EncodeToString(id)
+	if cp := s.checkpoint(); cp != nil {
+		// continue from the last checkpoint, the initial
+		// state becomes the next version if it differs
+		s.data.id = cp.ID
+		if !cp.Clean {
+			// clients may have newer versions than the checkpoint
+			s.data.id = randomID()
+			s.logger().Warn("velox: checkpoint was not saved by shutdown, clients will resync", "version", cp.Version)
+		}
+		s.data.version = cp.Version
+		s.data.patcher.patch(cp.Data)
+		delta, err := s.data.patcher.patch(b)
+		if err != nil || len(cp.Data) == 0 {
+			if !bytes.Equal(b, cp.Data) {
+				s.data.version++
+			}
+		} else if !bytes.Equal(delta, []byte(`{}`)) {
+			s.data.delta = delta
+			s.data.prev = cp.Data
+			s.data.version++
+		}
+		s.data.bytes = b
+	} else {
+		s.data.bytes = b
+		// seed the merge patcher cache with the initial state
+		s.data.patcher.patch(b)
+		s.data.id = randomID()
+		s.data.version = 1
+		if s.Data == nil {
+			// backplane follower, nothing to send until received
+			s.data.id = ""
+			s.data.version = 0
+		}
 	}
-	s.data.version = 1
 	s.data.mut.Unlock()
+	s.unclean()
 	// set connection fields
 	s.connMut.Lock()
 	s.conns = map[int64]*conn{}
 	s.connMut.Unlock()
+	if err := s.subscribeBackplane(); err != nil {
+		return fmt.Errorf("backplane: %w", err)
+	}
 	return nil
 }
 
+func randomID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 func (s *State) self() *State {
 	return s
 }
 
 func (s *State) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	conn, err := s.Handle(w, r)
+	var status *StatusError
+	if errors.As(err, &status) {
+		status.write(w)
+		return
+	}
 	if err != nil {
-		log.Printf("velox: serve: %s", err)
+		s.logger().Warn("velox: serve failed", "addr", r.RemoteAddr, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -117,23 +244,49 @@ func (state *State) Handle(w http.ResponseWriter, r *http.Request) (Conn, error)
 	if err := state.init(); err != nil {
 		return nil, fmt.Errorf("init: %w", err)
 	}
+	//refuse unauthorized connections, then those over
+	//the limits, or once shutting down
+	md, err := state.authorize(r)
+	if err != nil {
+		return nil, err
+	}
+	release, err := state.admit(r)
+	if err != nil {
+		return nil, err
+	}
 	version := int64(0)
-	//matching id, allow user to pick version
-	if id := r.URL.Query().Get("id"); id != "" && id == state.data.id {
-		if v, err := strconv.ParseInt(r.URL.Query().Get("v"), 10, 64); err == nil && v > 0 {
+	//matching id, allow user to pick version, either in the query
+	//or as reconnecting EventSources do, in the Last-Event-ID header
+	//(filtered views are per connection, so they always start fresh)
+	id, v := r.URL.Query().Get("id"), r.URL.Query().Get("v")
+	if id == "" {
+		id, v = parseEventID(r.Header.Get("Last-Event-ID"))
+	}
+	if id != "" && id == state.ID() && state.Filter == nil {
+		if v, err := strconv.ParseInt(v, 10, 64); err == nil && v > 0 {
 			version = v
 		}
 	}
 	//set initial connection state
-	conn := newConn(atomic.AddInt64(&connectionID, 1), r.RemoteAddr, state, version)
+	conn := newConn(atomic.AddInt64(&connectionID, 1), r, state, version)
+	conn.release = release
+	conn.metadata = md
 	//attempt connection over transport
 	//(negotiate websockets / start eventsource emitter)
 	//return when connected
 	if err := conn.connect(w, r); err != nil {
+		release()
+		conn.cancel()
 		return nil, fmt.Errorf("velox connection failed: %s", err)
 	}
 	//hand over to state to keep in sync
-	state.subscribe(conn)
+	ok := state.subscribe(conn)
+	state.hookConnect(conn)
+	if !ok {
+		//shutdown began while connecting
+		conn.goodbye(state.retryHint())
+		return conn, nil
+	}
 	//do an initial push only to this client
 	conn.Push()
 	//pass connection to user
@@ -142,29 +295,71 @@ func (state *State) Handle(w http.ResponseWriter, r *http.Request) (Conn, error)
 
 // ID uniquely identifies this state object
 func (s *State) ID() string {
+	s.data.mut.RLock()
+	defer s.data.mut.RUnlock()
 	return s.data.id
 }
 
 // Version of this state object (when the underlying struct is
 // and a Push is performed, this version number is incremented).
 func (s *State) Version() int64 {
+	s.data.mut.RLock()
+	defer s.data.mut.RUnlock()
 	return s.data.version
 }
 
-func (s *State) subscribe(conn *conn) {
+// deltaSince returns a delta from the given version to the
+// current version, if one is available. Requires the data lock.
+func (s *State) deltaSince(version int64) ([]byte, bool) {
+	d := &s.data
+	if d.delta != nil && version == d.version-1 {
+		return d.delta, true
+	}
+	return d.history.since(version, s.codec())
+}
+
+// jsonPatchSince returns the JSON Patch from the given version,
+// which must be the previous version. Must hold data lock.
+func (s *State) jsonPatchSince(version int64) ([]byte, bool) {
+	d := &s.data
+	if d.prev == nil || d.bytes == nil || version != d.version-1 {
+		return nil, false
+	}
+	//calculated once per version, on demand
+	d.ops.mut.Lock()
+	defer d.ops.mut.Unlock()
+	if d.ops.version != d.version {
+		ops, err := jsonPatchBytes(s.codec(), d.prev, d.bytes, s.ArrayKey)
+		if err != nil {
+			s.logger().Error("velox: json patch failed", "version", d.version, "err", err)
+			return nil, false
+		}
+		d.ops.version = d.version
+		d.ops.bytes = ops
+	}
+	return d.ops.bytes, true
+}
+
+// subscribe the connection to pushes, returns false once shutting down
+func (s *State) subscribe(conn *conn) bool {
 	//subscribe
 	conn.waiter.Add(1)
 	s.connMut.Lock()
 	s.conns[conn.id] = conn
+	closing := s.closing
 	s.connMut.Unlock()
 	//and then unsubscribe on close
 	go func() {
 		<-conn.connectedCh //this unblocks before wait
+		conn.release()
+		conn.cancel()
 		s.connMut.Lock()
 		delete(s.conns, conn.id)
 		s.connMut.Unlock()
+		s.hookDisconnect(conn)
 		conn.waiter.Done()
 	}()
+	return !closing
 }
 
 // NumConnections currently active
@@ -184,15 +379,15 @@ func (s *State) Push() bool {
 	}
 	//attempt to mark state as 'pushing'
 	if atomic.CompareAndSwapUint32(&s.push.ing, 0, 1) {
-		if s.Debug {
-			log.Printf("velox: Push() starting new push")
+		if l := s.logger(); debugging(l) {
+			l.Debug("velox: push started")
 		}
 		go s.gopush()
 		return true
 	}
 	//if already pushing, mark queued
-	if s.Debug {
-		log.Printf("velox: Push() already pushing, marking queued")
+	if l := s.logger(); debugging(l) {
+		l.Debug("velox: push queued")
 	}
 	atomic.StoreUint32(&s.push.queued, 1)
 	return false
@@ -218,21 +413,32 @@ func (s *State) gopush() {
 			s.Push()
 		}
 	}()
+	s.pushLocked(t0)
+}
+
+// pushLocked marshals and diffs the data, then sends any new version to
+// each subscriber. Returns the current version. Requires the push lock.
+func (s *State) pushLocked(t0 time.Time) (int64, error) {
 	//calculate new json state
+	logger := s.logger()
+	s.push.last.Store(t0.UnixNano())
 	newBytes, err := s.Data()
 	if err != nil {
-		log.Printf("velox: marshal failed: %s", err)
-		return
+		logger.Error("velox: marshal failed", "err", err)
+		return 0, err
 	}
-	if s.Debug {
-		log.Printf("velox: gopush marshaled %d bytes", len(newBytes))
+	debug := debugging(logger)
+	if debug {
+		logger.Debug("velox: push marshalled", "bytes", len(newBytes))
 	}
 	s.data.mut.Lock()
 	changed := false
 	if bytes.Equal(newBytes, []byte("null")) {
 		// special case, clear data
 		s.data.bytes = nil
+		s.data.prev = nil
 		s.data.delta = nil
+		s.data.history.reset()
 		changed = true
 	} else {
 		// ensure non-nil
@@ -249,28 +455,62 @@ func (s *State) gopush() {
 			// then calculate change set from last version
 			// NOTE: patch may contain references to localStruct
 			s.data.delta = delta
+			s.data.prev = s.data.bytes
 			s.data.bytes = newBytes
+			s.data.history.add(s.data.version+1, delta, s.data.prev, s.codec(), s.DeltaHistory, s.DeltaHistoryBytes)
 			changed = true
-			if s.Debug {
-				log.Printf("velox: gopush changed, delta=%s", string(delta))
+			if debug {
+				logger.Debug("velox: push changed", "version", s.data.version+1, "delta", len(delta))
 			}
-		} else if s.Debug {
-			log.Printf("velox: gopush no change detected")
+		} else if debug {
+			logger.Debug("velox: push unchanged", "version", s.data.version)
 		}
 	}
 	// bump if changed
+	resync := false
 	if changed {
 		s.data.version++
+		s.data.changed = time.Now()
+		// versions built on another instance's version are named
+		// by a new id, so each id and version is a single state
+		if s.data.origin != s.backplane.origin {
+			s.data.id = randomID()
+			s.data.origin = s.backplane.origin
+			resync = true
+		}
 	}
 	dversion := s.data.version
+	deltaBytes := len(s.data.delta)
 	s.data.mut.Unlock()
+	s.hookPush(PushInfo{
+		Version:     dversion,
+		Changed:     changed,
+		Bytes:       len(newBytes),
+		DeltaBytes:  deltaBytes,
+		Duration:    time.Since(t0),
+		Connections: s.NumConnections(),
+	})
+	if changed {
+		s.save()
+		s.publish()
+	}
+	if resync {
+		s.resyncAll()
+	}
 	//send this new change to each subscriber
+	s.fanout(dversion)
+	return dversion, nil
+}
+
+// fanout pushes the given version to each stale connection
+func (s *State) fanout(version int64) {
 	s.connMut.Lock()
 	for _, c := range s.conns {
-		if c.Version() != dversion {
-			go c.Push()
+		//connections already pushing skip to this version next,
+		//rather than each push starting a goroutine
+		if c.sentVersion() != version && c.schedule() {
+			go c.drain()
 		}
 	}
 	s.connMut.Unlock()
-	//defered cleanup()
 }
@@ -0,0 +1,196 @@
+package velox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checkpoint is the persisted form of a State: its latest
+// data, and the id and version clients use to resume.
+type Checkpoint struct {
+	ID      string          `json:"id"`
+	Version int64           `json:"version"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	// Clean is set by State.Shutdown. Clients may have been sent newer
+	// versions than a checkpoint saved while running, so without it,
+	// the State restores the data and version under a new id, and
+	// reconnecting clients resync in full.
+	Clean bool `json:"clean,omitempty"`
+}
+
+// Store persists State checkpoints, so that a restarted
+// State continues with the same data, id and version. Saves
+// run in the background, one at a time: versions pushed during
+// a save are coalesced, and only the latest is saved next.
+// Only checkpoints saved by Shutdown keep their id on restore.
+type Store interface {
+	// Load returns the latest checkpoint, or nil if there is none.
+	Load() (*Checkpoint, error)
+	// Save replaces the latest checkpoint.
+	Save(cp *Checkpoint) error
+}
+
+// FileStore is a Store which keeps the latest checkpoint as JSON in a file.
+// Saves are atomic, the file is replaced rather than rewritten.
+type FileStore struct {
+	Path string
+	mut  sync.Mutex
+}
+
+// NewFileStore returns a FileStore using the given file path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (f *FileStore) Load() (*Checkpoint, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	b, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (f *FileStore) Save(cp *Checkpoint) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after rename
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// checkpoint loads the last checkpoint from the Store once
+func (s *State) checkpoint() *Checkpoint {
+	s.store.once.Do(func() {
+		if s.Store == nil {
+			return
+		}
+		cp, err := s.Store.Load()
+		if err != nil {
+			s.logger().Error("velox: store load failed", "err", err)
+			return
+		}
+		if cp != nil && cp.ID != "" && cp.Version > 0 {
+			s.store.restored = cp
+		}
+	})
+	return s.store.restored
+}
+
+// save the current version to the Store in the background,
+// or once the save in progress completes
+func (s *State) save() {
+	s.saveAs(false)
+}
+
+// saveAs saves the current version, marked clean or not
+func (s *State) saveAs(clean bool) {
+	if s.Store == nil {
+		return
+	}
+	s.store.mut.Lock()
+	defer s.store.mut.Unlock()
+	s.store.dirty = true
+	s.store.clean = clean
+	if s.store.idle == nil {
+		s.store.idle = make(chan struct{})
+		go s.saveLatest()
+	}
+}
+
+// saveLatest saves the current version until no newer version is waiting
+func (s *State) saveLatest() {
+	for {
+		s.store.mut.Lock()
+		if !s.store.dirty {
+			close(s.store.idle)
+			s.store.idle = nil
+			s.store.mut.Unlock()
+			return
+		}
+		s.store.dirty = false
+		clean := s.store.clean
+		s.store.mut.Unlock()
+		cp := s.current()
+		cp.Clean = clean
+		if err := s.Store.Save(cp); err != nil {
+			s.logger().Error("velox: store save failed", "version", cp.Version, "err", err)
+		}
+	}
+}
+
+// current returns a checkpoint of the current version
+func (s *State) current() *Checkpoint {
+	s.data.mut.RLock()
+	defer s.data.mut.RUnlock()
+	return &Checkpoint{
+		ID:      s.data.id,
+		Version: s.data.version,
+		Data:    s.data.bytes,
+	}
+}
+
+// unclean marks the restored checkpoint as in use before any version is
+// sent, so that it is not restored with the same id after a crash
+func (s *State) unclean() {
+	if s.Store == nil || s.store.restored == nil || !s.store.restored.Clean {
+		return
+	}
+	if err := s.Store.Save(s.current()); err != nil {
+		s.logger().Error("velox: store save failed", "err", err)
+	}
+}
+
+// saved waits for the saves in progress
+func (s *State) saved(ctx context.Context) error {
+	s.store.mut.Lock()
+	idle := s.store.idle
+	s.store.mut.Unlock()
+	if idle == nil {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// restoreInto unmarshals checkpoint data into gostruct
+func restoreInto(codec Codec, gostruct interface{}, locker sync.Locker, data json.RawMessage) error {
+	if locker != nil {
+		locker.Lock()
+		defer locker.Unlock()
+	}
+	return codec.Unmarshal(data, gostruct)
+}
@@ -0,0 +1,171 @@
+package velox_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	velox "github.com/jpillora/velox/go"
+)
+
+func TestFileStore(t *testing.T) {
+	store := velox.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	if cp, err := store.Load(); err != nil || cp != nil {
+		t.Fatalf("Expected empty store, got %+v (%v)", cp, err)
+	}
+	want := &velox.Checkpoint{ID: "abc", Version: 7, Data: []byte(`{"a":1}`)}
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got.ID != want.ID || got.Version != want.Version || string(got.Data) != string(want.Data) {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestStateStoreRestore(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	store := velox.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	// first run
+	first := &TestStruct{Name: "first"}
+	first.State.Throttle = 10 * time.Millisecond
+	first.State.Store = store
+	velox.SyncHandler(first)
+	first.Lock()
+	first.Count = 3
+	first.Unlock()
+	first.Push()
+	waitFor(t, "push", func() bool { return first.State.Version() == 2 })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := first.State.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if cp, _ := store.Load(); cp == nil || cp.Version != 2 || !cp.Clean {
+		t.Fatalf("Expected a clean checkpoint of version 2, got %+v", cp)
+	}
+	// second run continues with the same data, id and version
+	second := &TestStruct{}
+	second.State.Throttle = 10 * time.Millisecond
+	second.State.Store = store
+	velox.SyncHandler(second)
+	if second.Name != "first" || second.Count != 3 {
+		t.Fatalf("Expected restored first/3, got %s/%d", second.Name, second.Count)
+	}
+	if second.State.ID() != first.State.ID() {
+		t.Fatalf("Expected id %s, got %s", first.State.ID(), second.State.ID())
+	}
+	if v := second.State.Version(); v != 2 {
+		t.Fatalf("Expected version 2, got %d", v)
+	}
+	// the checkpoint is in use, until the next shutdown
+	if cp, _ := store.Load(); cp == nil || cp.Clean {
+		t.Fatalf("Expected the checkpoint to be marked in use, got %+v", cp)
+	}
+	second.Lock()
+	second.Count = 4
+	second.Unlock()
+	second.Push()
+	waitFor(t, "push", func() bool { return second.State.Version() == 3 })
+}
+
+func TestStateStoreChangedOnRestart(t *testing.T) {
+	store := velox.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	store.Save(&velox.Checkpoint{ID: "abc", Version: 5, Data: []byte(`{"a":1}`), Clean: true})
+	// a State without a struct to restore into,
+	// has different initial data to the checkpoint
+	s := &velox.State{
+		Data:  func() (json.RawMessage, error) { return json.RawMessage(`{"a":2}`), nil },
+		Store: store,
+	}
+	if s.ID() != "" {
+		t.Fatal("Expected uninitialised state")
+	}
+	s.Push()
+	waitFor(t, "push", func() bool { return s.Version() > 0 })
+	if s.ID() != "abc" || s.Version() != 6 {
+		t.Fatalf("Expected abc/6, got %s/%d", s.ID(), s.Version())
+	}
+}
+
+func TestStateStoreCrash(t *testing.T) {
+	store := velox.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	// a checkpoint saved while running, clients may have newer versions
+	store.Save(&velox.Checkpoint{ID: "abc", Version: 5, Data: []byte(`{"a":1}`)})
+	s := &velox.State{
+		Data:  func() (json.RawMessage, error) { return json.RawMessage(`{"a":1}`), nil },
+		Store: store,
+	}
+	s.Push()
+	waitFor(t, "push", func() bool { return s.Version() > 0 })
+	// the version continues under a new id, so those clients resync
+	if s.ID() == "abc" || s.ID() == "" || s.Version() != 5 {
+		t.Fatalf("Expected a new id at version 5, got %s/%d", s.ID(), s.Version())
+	}
+}
+
+// blockingStore holds each save until released
+type blockingStore struct {
+	release chan struct{}
+	mut     sync.Mutex
+	saved   []int64
+	clean   bool // the last save was clean
+}
+
+func (b *blockingStore) Load() (*velox.Checkpoint, error) { return nil, nil }
+
+func (b *blockingStore) Save(cp *velox.Checkpoint) error {
+	<-b.release
+	b.mut.Lock()
+	b.saved = append(b.saved, cp.Version)
+	b.clean = cp.Clean
+	b.mut.Unlock()
+	return nil
+}
+
+func TestStateStoreBackground(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		Count int `json:"count"`
+	}
+	store := &blockingStore{release: make(chan struct{})}
+	data := &TestStruct{}
+	data.State.Throttle = velox.MinThrottle
+	data.State.Store = store
+	velox.SyncHandler(data)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// pushes do not wait for a save in progress
+	for i := 1; i <= 5; i++ {
+		data.Lock()
+		data.Count = i
+		data.Unlock()
+		if res, err := data.State.PushAndWait(ctx); err != nil || res.Version != int64(i+1) {
+			t.Fatalf("Unexpected push %+v (%v)", res, err)
+		}
+	}
+	close(store.release)
+	// shutdown waits for the latest version to be saved clean,
+	// versions pushed during the first save are coalesced
+	if err := data.State.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	n := len(store.saved)
+	if n < 2 || n > 3 || store.saved[0] >= 6 || store.saved[n-1] != 6 || !store.clean {
+		t.Fatalf("Expected an early save then version 6 clean, got %v (clean %v)", store.saved, store.clean)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"reflect"
 	"sync"
 )
 
@@ -49,7 +50,16 @@ func SyncHandler(gostruct interface{}) http.Handler {
 		} else if l, ok := gostruct.(sync.Locker); ok {
 			locker = l
 		}
+		// continue from the last run
+		if cp := s.checkpoint(); cp != nil && len(cp.Data) > 0 {
+			if err := restoreInto(s.codec(), gostruct, locker, cp.Data); err != nil {
+				s.logger().Error("velox: store restore failed", "version", cp.Version, "err", err)
+			}
+		}
 		bindAll(gostruct, locker, s)
+		if s.Unmarshal == nil {
+			s.Unmarshal = Unmarshal(gostruct)
+		}
 		if err := s.init(); err != nil {
 			panic("velox: " + err.Error())
 		}
@@ -103,10 +113,58 @@ func Marshal(gostruct interface{}) MarshalFunc {
 				outErr = fmt.Errorf("velox sync panic during marshal: %v", r)
 			}
 		}()
-		b, err := json.Marshal(gostruct)
+		b, err := codecOf(gostruct).Marshal(gostruct)
 		if err != nil {
 			return nil, fmt.Errorf("velox sync failed: %s", err)
 		}
 		return b, nil
 	}
 }
+
+// UnmarshalFunc replaces the underlying data of a State with the
+// given state, adopted from another instance over a Backplane.
+type UnmarshalFunc func(data json.RawMessage) error
+
+// Unmarshal returns an UnmarshalFunc which replaces the contents of
+// gostruct, locking it like Marshal. VMap and VSlice fields are rebound
+// to the embedded State, if any.
+func Unmarshal(gostruct interface{}) UnmarshalFunc {
+	locker, pusher := structLocker(gostruct)
+	return func(data json.RawMessage) error {
+		if locker != nil {
+			locker.Lock()
+			defer locker.Unlock()
+		}
+		return unmarshalLocked(gostruct, data, locker, pusher)
+	}
+}
+
+// structLocker returns the lock which guards writes to gostruct,
+// and the embedded State, if any
+func structLocker(gostruct interface{}) (sync.Locker, Pusher) {
+	var locker sync.Locker
+	var pusher Pusher
+	if se, ok := gostruct.(stateEmbedded); ok {
+		pusher = se.self()
+		locker = se.self().Locker
+	}
+	if l, ok := gostruct.(sync.Locker); ok && locker == nil {
+		locker = l
+	}
+	return locker, pusher
+}
+
+// unmarshalLocked replaces the contents of gostruct, which must be locked
+func unmarshalLocked(gostruct interface{}, data json.RawMessage, locker sync.Locker, pusher Pusher) error {
+	codec := codecOf(gostruct)
+	//reject data which does not fit the struct before modifying it
+	if t := reflect.TypeOf(gostruct); t.Kind() == reflect.Ptr {
+		if err := codec.Unmarshal(data, reflect.New(t.Elem()).Interface()); err != nil {
+			return err
+		}
+	}
+	clearForUnmarshal(gostruct)
+	err := codec.Unmarshal(data, gostruct)
+	bindAll(gostruct, locker, pusher)
+	return err
+}
@@ -45,8 +45,8 @@ func TestBasicSync(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to get next event: %v", err)
 	}
-	if id != "0" {
-		t.Fatalf("Expected event ID 0, got %s", id)
+	if id != "" {
+		t.Fatalf("Expected no event ID, got %s", id)
 	}
 	if !u.Ping {
 		t.Fatalf("Expected ping, got: %+v", u)
@@ -58,8 +58,8 @@ func TestBasicSync(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to get next event: %v", err)
 		}
-		if id != strconv.Itoa(nid) {
-			t.Fatalf("Expected event ID %d, got %s", nid, id)
+		if expect := test.ID() + "-" + strconv.Itoa(nid); id != expect {
+			t.Fatalf("Expected event ID %s, got %s", expect, id)
 		}
 		nid++
 		if u.Ping {
@@ -118,8 +118,8 @@ func TestMultiSync(t *testing.T) {
 			if err != nil {
 				return fmt.Errorf("Failed to get next event: %v", err)
 			}
-			if id != "0" {
-				return fmt.Errorf("Expected event ID 0, got %s", id)
+			if id != "" {
+				return fmt.Errorf("Expected no event ID, got %s", id)
 			}
 			if !u.Ping {
 				return fmt.Errorf("Expected ping, got: %+v", u)
@@ -187,6 +187,8 @@ type testClient struct {
 	http *http.Client
 	body io.ReadCloser
 	dec  *eventsource.Decoder
+	// lastEventID resumes as a reconnecting EventSource would
+	lastEventID string
 }
 
 func (c *testClient) do(req *http.Request) (*http.Response, error) {
@@ -208,6 +210,9 @@ func (c *testClient) connect(ctx context.Context) error {
 		return fmt.Errorf("Failed to create request: %v", err)
 	}
 	req.Header.Set("Accept", "text/event-stream")
+	if c.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", c.lastEventID)
+	}
 	resp, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %v", err)
@@ -249,6 +254,59 @@ func (c *testClient) next() (update *velox.Update, eventID string, err error) {
 	return u, e.ID, nil
 }
 
+func TestLastEventIDResume(t *testing.T) {
+	type TestStruct struct {
+		velox.State
+		sync.Mutex
+		A, B    int
+		Padding string
+	}
+	test := &TestStruct{Padding: "some data which is not changing between versions"}
+	test.State.Throttle = 10 * time.Millisecond
+	server := httptest.NewServer(velox.SyncHandler(test))
+	defer server.Close()
+	client := &testClient{id: 1, url: server.URL}
+	if err := client.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	client.next() // ping
+	_, lastID, err := client.next()
+	if err != nil || lastID != test.ID()+"-1" {
+		t.Fatalf("Expected event ID %s-1, got %q: %v", test.ID(), lastID, err)
+	}
+	client.disconnect()
+	// changed while disconnected
+	test.Lock()
+	test.A = 1
+	test.Unlock()
+	test.Push()
+	waitFor(t, "version 2", func() bool { return test.Version() == 2 })
+	resume := &testClient{id: 2, url: server.URL, lastEventID: lastID}
+	if err := resume.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer resume.disconnect()
+	resume.next() // ping
+	update, id, err := resume.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update.Delta || update.Version != 2 || id != test.ID()+"-2" {
+		t.Fatalf("Expected delta to version 2, got %+v (event ID %q)", update, id)
+	}
+	assertJSONEqual(t, `{"A":1}`, string(update.Body))
+	// an unknown state id receives the full state
+	other := &testClient{id: 3, url: server.URL, lastEventID: "other-1"}
+	if err := other.connect(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	defer other.disconnect()
+	other.next() // ping
+	if update, _, err := other.next(); err != nil || update.Delta || update.Version != 2 {
+		t.Fatalf("Expected full state, got %+v: %v", update, err)
+	}
+}
+
 func TestNilMapMarshal(t *testing.T) {
 	// Reproduces the panic: reflect: call of reflect.Value.Set on zero Value
 	// when a struct with a nil map is pushed.
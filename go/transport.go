@@ -2,16 +2,48 @@ package velox
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 )
 
+// Transport names a connection transport
+type Transport string
+
+const (
+	TransportSSE       Transport = "sse"       // Server-Sent Events (EventSource)
+	TransportWebSocket Transport = "websocket" // WebSockets
+	TransportAuto      Transport = "auto"      // WebSockets, falling back to SSE (Client only)
+)
+
+// ErrSendTimeout is returned when an update could not
+// be written to a connection within State.WriteTimeout
+var ErrSendTimeout = errors.New("velox: send timeout")
+
 // Update is a single message sent to the client
 type Update struct {
-	ID      string          `json:"id,omitempty"`
-	Ping    bool            `json:"ping,omitempty"`
-	Delta   bool            `json:"delta,omitempty"`
-	Version int64           `json:"version,omitempty"` //53 usable bits
-	Body    json.RawMessage `json:"body,omitempty"`
+	ID       string          `json:"id,omitempty"`
+	Ping     bool            `json:"ping,omitempty"`
+	Delta    bool            `json:"delta,omitempty"`
+	Version  int64           `json:"version,omitempty"` //53 usable bits
+	Body     json.RawMessage `json:"body,omitempty"`
+	Format   DeltaFormat     `json:"format,omitempty"`   // delta format, when not DeltaMergePatch
+	Reply    int64           `json:"reply,omitempty"`    // id of the Message this update replies to
+	Error    string          `json:"error,omitempty"`    // reply error
+	Conflict bool            `json:"conflict,omitempty"` // reply error is ErrConflict
+	Event    string          `json:"event,omitempty"`    // name of an out-of-band event
+	Payload  json.RawMessage `json:"payload,omitempty"`  // reply result or event payload, never state
+	Goodbye  bool            `json:"goodbye,omitempty"`  // the server is shutting down
+	Retry    int64           `json:"retry,omitempty"`    // milliseconds to wait before reconnecting
+	stateID  string          // id of the state this version belongs to
+}
+
+// Message is a single message sent from the client to the
+// server, only supported by the WebSocket transport.
+type Message struct {
+	Ack     int64           `json:"ack,omitempty"` // version applied by the client
+	ID      int64           `json:"id,omitempty"`
+	Action  string          `json:"action,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 type transport interface {
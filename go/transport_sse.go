@@ -5,8 +5,12 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,10 +24,12 @@ var encodePool = sync.Pool{
 type eventSourceTransport struct {
 	mut          sync.Mutex
 	writeTimeout time.Duration
+	rc           *http.ResponseController // nil without write deadlines
 	w            http.ResponseWriter
 	gzw          *gzipResponseWriter // non-nil if gzip is active
 	isConnected  bool
 	connected    chan struct{}
+	codec        Codec
 }
 
 func (es *eventSourceTransport) connect(w http.ResponseWriter, r *http.Request) error {
@@ -37,6 +43,12 @@ func (es *eventSourceTransport) connect(w http.ResponseWriter, r *http.Request)
 			es.close()
 		}
 	}()
+	//abort timed out writes with a write deadline where supported,
+	//otherwise they are abandoned in the background
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err == nil {
+		es.rc = rc
+	}
 	//eventsource headers
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Vary", "Accept")
@@ -72,7 +84,7 @@ func (es *eventSourceTransport) send(upd *Update) error {
 
 	buf := encodePool.Get().(*bytes.Buffer)
 	buf.Reset()
-	if err := json.NewEncoder(buf).Encode(upd); err != nil {
+	if err := es.encode(buf, upd); err != nil {
 		encodePool.Put(buf)
 		return err
 	}
@@ -81,26 +93,85 @@ func (es *eventSourceTransport) send(upd *Update) error {
 	if len(b) > 0 && b[len(b)-1] == '\n' {
 		b = b[:len(b)-1]
 	}
-	// TODO: improve this to not use a goroutine
-	// instead it should hijack and use a tcp write-timeout
-	sent := make(chan error)
+	event := eventsource.Event{
+		ID:   eventID(upd),
+		Data: b,
+	}
+	if es.rc != nil {
+		err := es.writeDeadline(event)
+		encodePool.Put(buf)
+		return err
+	}
+	w := es.w
+	sent := make(chan error, 1)
 	go func() {
-		err := eventsource.WriteEvent(es.w, eventsource.Event{
-			ID:   strconv.FormatInt(upd.Version, 10),
-			Data: b,
-		})
-		sent <- err
+		sent <- eventsource.WriteEvent(w, event)
 	}()
 	select {
 	case <-time.After(es.writeTimeout):
-		// don't return buf to pool; goroutine may still be writing
-		return errors.New("timeout")
+		// don't return buf to pool; goroutine may still be writing,
+		// so the connection is closed as if the write was aborted
+		return fmt.Errorf("%w: %w", ErrSendTimeout, os.ErrDeadlineExceeded)
 	case err := <-sent:
 		encodePool.Put(buf)
 		return err
 	}
 }
 
+// writeDeadline writes the event, aborting it after writeTimeout
+func (es *eventSourceTransport) writeDeadline(event eventsource.Event) error {
+	if err := es.rc.SetWriteDeadline(time.Now().Add(es.writeTimeout)); err != nil {
+		return err
+	}
+	// hide http.Flusher, whose Flush cannot report errors
+	err := eventsource.WriteEvent(struct{ io.Writer }{es.w}, event)
+	if err == nil && es.gzw != nil {
+		err = es.gzw.gz.Flush()
+	}
+	if err == nil {
+		err = es.rc.Flush()
+	}
+	if err != nil {
+		// a timed out stream is incomplete, so the connection is closed
+		return deadlineExceeded(err)
+	}
+	// clear the deadline, http/2 resets idle streams when it passes
+	return es.rc.SetWriteDeadline(time.Time{})
+}
+
+// eventID identifies a version as "<state id>-<version>", which
+// EventSource sends back as Last-Event-ID when it reconnects.
+// Pings have no id, so the last version's id is kept.
+func eventID(upd *Update) string {
+	if upd.Version == 0 {
+		return ""
+	}
+	return upd.stateID + "-" + strconv.FormatInt(upd.Version, 10)
+}
+
+// parseEventID splits an eventID into its state id and version
+func parseEventID(eventID string) (id, version string) {
+	i := strings.LastIndexByte(eventID, '-')
+	if i < 0 {
+		return "", ""
+	}
+	return eventID[:i], eventID[i+1:]
+}
+
+// encode the update into buf, the default codec
+// encodes directly into the pooled buffer
+func (es *eventSourceTransport) encode(buf *bytes.Buffer, upd *Update) error {
+	if es.codec == nil || es.codec == JSONCodec {
+		return json.NewEncoder(buf).Encode(upd)
+	}
+	b, err := es.codec.Marshal(upd)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
 func (es *eventSourceTransport) wait() error {
 	<-es.connected
 	return nil
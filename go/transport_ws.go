@@ -22,22 +22,42 @@ var defaultUpgrader = websocket.Upgrader{
 type websocketsTransport struct {
 	writeTimeout time.Duration
 	conn         *websocket.Conn
+	recv         func(b []byte) // called with each message from the client, as JSON
+	encodings    []Encoding     // offered to the client
+	encoding     Encoding       // negotiated, nil for JSON
+	cache        *wireCache     // shared by connections, nil when filtered
+	codec        Codec
 }
 
 func (ws *websocketsTransport) connect(w http.ResponseWriter, r *http.Request) error {
-	conn, err := defaultUpgrader.Upgrade(w, r, nil)
+	upgrader := defaultUpgrader
+	upgrader.Subprotocols = subprotocols(ws.encodings)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return fmt.Errorf("[velox] cannot upgrade connection: %s", err)
 	}
 	conn.EnableWriteCompression(true)
 	conn.SetCompressionLevel(gzip.BestSpeed)
 	ws.conn = conn
+	ws.encoding = negotiatedEncoding(ws.encodings, conn.Subprotocol())
 	return nil
 }
 
 func (ws *websocketsTransport) send(upd *Update) error {
+	b, err := ws.cache.encode(ws.codec, ws.encoding, upd)
+	if err != nil {
+		return err
+	}
+	if ws.encoding == nil {
+		return ws.write(websocket.TextMessage, b)
+	}
+	return ws.write(websocket.BinaryMessage, b)
+}
+
+// write with a deadline, a stalled write is aborted
+func (ws *websocketsTransport) write(messageType int, b []byte) error {
 	ws.conn.SetWriteDeadline(time.Now().Add(ws.writeTimeout))
-	return ws.conn.WriteJSON(upd)
+	return deadlineExceeded(ws.conn.WriteMessage(messageType, b))
 }
 
 func (ws *websocketsTransport) wait() error {
@@ -47,12 +67,21 @@ func (ws *websocketsTransport) wait() error {
 		//from clients. currently hardcoded to 25s so timeout
 		//after 30s.
 		ws.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-		if _, _, err := ws.conn.ReadMessage(); err != nil {
+		t, b, err := ws.conn.ReadMessage()
+		if err != nil {
 			if err == io.EOF {
 				return nil
 			}
 			return err
 		}
+		if t == websocket.BinaryMessage && ws.encoding != nil {
+			if b, err = wireToJSON(ws.codec, ws.encoding, b); err != nil {
+				continue //invalid message
+			}
+		}
+		if ws.recv != nil {
+			ws.recv(b)
+		}
 	}
 }
 func (ws *websocketsTransport) close() error {
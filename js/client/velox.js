@@ -1,4 +1,5 @@
 const jsonpatch = require("json-merge-patch");
+const jsonpatch6902 = require("fast-json-patch");
 const merge = require("./merge");
 const parseUrl = require("url-parse");
 const Backoff = require("backo");
@@ -69,7 +70,13 @@ class Velox {
     this.onchange = function () {
       /*noop*/
     };
+    this.onevent = function (name, payload) {
+      /*noop*/
+    };
+    this.listeners = {}; //named event handlers
     this.connected = false;
+    this.calls = {}; //pending action replies
+    this.callID = 0;
     this.connect();
   }
   connect() {
@@ -108,6 +115,14 @@ class Velox {
     if (this.id) {
       u.query.id = this.id;
     }
+    //request json patch deltas (array-aware)
+    if (this.opts.format) {
+      u.query.format = this.opts.format;
+    }
+    //confirm applied versions (websockets only)
+    if (this.opts.ack && this.ws) {
+      u.query.ack = 1;
+    }
     //add auth
     if (this.opts.username) {
       u.username = this.opts.username;
@@ -141,6 +156,11 @@ class Velox {
   }
   cleanup() {
     clearTimeout(this.pingout.t);
+    //replies to pending calls will never arrive
+    for (let id in this.calls) {
+      this.calls[id].reject("disconnected");
+    }
+    this.calls = {};
     if (!this.conn) {
       return;
     }
@@ -160,6 +180,42 @@ class Velox {
       return c.send(data);
     }
   }
+  call(action, payload) {
+    //invoke a server-side action, resolves with its result
+    return new Promise((resolve, reject) => {
+      let c = this.conn;
+      if (!this.ws) {
+        return reject("actions require websockets");
+      }
+      if (!c || c.readyState !== c.OPEN) {
+        return reject("not connected");
+      }
+      let id = ++this.callID;
+      this.calls[id] = { resolve, reject };
+      this.send(JSON.stringify({ id, action, payload }));
+    });
+  }
+  on(name, fn) {
+    //handle named events sent with State.Broadcast or Conn.Send
+    (this.listeners[name] = this.listeners[name] || []).push(fn);
+    return this;
+  }
+  off(name, fn) {
+    let fns = this.listeners[name] || [];
+    let i = fns.indexOf(fn);
+    if (i >= 0) fns.splice(i, 1);
+    return this;
+  }
+  emit(name, payload) {
+    this.onevent(name, payload);
+    for (let fn of (this.listeners[name] || []).slice()) {
+      try {
+        fn(payload);
+      } catch (err) {
+        this.onerror(err);
+      }
+    }
+  }
   pingin() {
     //ping receievd by server, reset last timer, start death timer for 45secs
     clearTimeout(this.pingin.t);
@@ -207,6 +263,27 @@ class Velox {
       this.pingin();
       return;
     }
+    if (update.goodbye) {
+      //server is shutting down, it closes the connection next
+      this.goodbyeDelay = update.retry || 0;
+      return;
+    }
+    if (update.event) {
+      //out-of-band event, not part of the state
+      this.emit(update.event, update.payload);
+      return;
+    }
+    if (update.reply) {
+      let call = this.calls[update.reply];
+      delete this.calls[update.reply];
+      if (!call) return;
+      if (update.error) {
+        call.reject(update.error);
+      } else {
+        call.resolve(update.payload);
+      }
+      return;
+    }
     if (update.id) {
       this.id = update.id;
     }
@@ -215,7 +292,19 @@ class Velox {
       return;
     }
     //perform update
-    if (update.delta) {
+    if (update.delta && update.format === "json-patch") {
+      // apply operations to doc, in-place
+      try {
+        let doc = jsonpatch6902.applyPatch(this.obj, update.body).newDocument;
+        if (doc !== this.obj) merge(this.obj, doc);
+      } catch (err) {
+        this.onerror(err);
+        //out of sync, resume with the full state
+        this.version = 0;
+        this.retry();
+        return;
+      }
+    } else if (update.delta) {
       // apply to doc
       try {
         jsonpatch.apply(this.obj, update.body);
@@ -230,6 +319,9 @@ class Velox {
     //update
     this.onupdate(this.obj);
     this.version = update.version;
+    if (this.opts.ack) {
+      this.send(JSON.stringify({ ack: update.version }));
+    }
     //successful msg resets retry counter
     this.backoff.reset();
   }
@@ -252,6 +344,12 @@ class Velox {
       }
       //if enabled, backoff retry connection
       let d = this.backoff.duration();
+      if (this.goodbyeDelay !== undefined) {
+        //reconnect when the server asked, spreading out its clients
+        d = this.goodbyeDelay;
+        this.goodbyeDelay = undefined;
+        this.backoff.reset();
+      }
       if (this.retrying && velox.online) {
         this.retry.t = setTimeout(this.connect.bind(this), d);
       }